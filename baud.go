@@ -0,0 +1,58 @@
+package main
+
+const (
+	// garbageLineRatio is the share of non-ASCII bytes above which a line is
+	// considered to be garbage, as produced by reading at the wrong baud rate.
+	garbageLineRatio = 0.3
+	// baudMismatchGarbageLines and baudMismatchUnparsableLines are the
+	// number of consecutive garbage or unparsable lines after which a baud
+	// rate mismatch is suspected.
+	baudMismatchGarbageLines    = 3
+	baudMismatchUnparsableLines = 10
+)
+
+// baudDetector guesses whether the serial port is read at the wrong baud
+// rate. This does not produce read errors but consistently unparsable lines,
+// which are mostly made up of non-ASCII bytes.
+type baudDetector struct {
+	garbageLines    int
+	unparsableLines int
+}
+
+// observe records a line read from the serial port and whether it could be
+// parsed.
+func (d *baudDetector) observe(line []byte, parsed bool) {
+	if parsed {
+		d.garbageLines = 0
+		d.unparsableLines = 0
+		return
+	}
+
+	d.unparsableLines++
+	if nonASCIIRatio(line) > garbageLineRatio {
+		d.garbageLines++
+	} else {
+		d.garbageLines = 0
+	}
+}
+
+// suspected returns whether the recent lines hint at a baud rate mismatch.
+func (d *baudDetector) suspected() bool {
+	return d.garbageLines >= baudMismatchGarbageLines || d.unparsableLines >= baudMismatchUnparsableLines
+}
+
+// nonASCIIRatio returns the share of bytes in line which are not printable
+// ASCII characters or whitespace.
+func nonASCIIRatio(line []byte) float64 {
+	if len(line) == 0 {
+		return 0
+	}
+
+	var nonASCII int
+	for _, b := range line {
+		if (b < ' ' || b > '~') && b != '\r' && b != '\n' && b != '\t' {
+			nonASCII++
+		}
+	}
+	return float64(nonASCII) / float64(len(line))
+}
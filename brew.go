@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// hxSample is the temperature of the heat exchanger at a point in time.
+type hxSample struct {
+	time time.Time
+	temp uint16
+}
+
+// brewDetector guesses whether a shot is being pulled. The machine does not
+// report the pump, but the fresh water flowing through the heat exchanger
+// makes its temperature drop quickly while the heating element turns on to
+// make up for it.
+type brewDetector struct {
+	// window is how far back the temperature drop is measured from and drop
+	// the minimum drop in it to detect brewing, detection is disabled if
+	// drop is 0.
+	window  time.Duration
+	drop    uint16
+	samples []hxSample
+}
+
+// add records a sample, evicts the samples older than the window and returns
+// whether the machine is brewing: the heating element is on and the heat
+// exchanger has cooled by at least drop from its highest temperature within
+// the window.
+func (d *brewDetector) add(t time.Time, hxTemp uint16, heating bool) bool {
+	if d.drop == 0 {
+		return false
+	}
+
+	d.samples = append(d.samples, hxSample{time: t, temp: hxTemp})
+	start := t.Add(-d.window)
+	evict := 0
+	for evict < len(d.samples)-1 && d.samples[evict].time.Before(start) {
+		evict++
+	}
+	d.samples = d.samples[evict:]
+
+	highest := hxTemp
+	for _, sample := range d.samples {
+		if sample.temp > highest {
+			highest = sample.temp
+		}
+	}
+	return heating && highest-hxTemp >= d.drop
+}
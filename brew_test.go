@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrewDetector(t *testing.T) {
+	type sample struct {
+		temp    uint16
+		heating bool
+	}
+	tests := map[string]struct {
+		samples  []sample
+		expected []bool
+	}{
+		"shot": {
+			samples:  []sample{{93, false}, {91, true}, {88, true}, {86, true}},
+			expected: []bool{false, false, true, true},
+		},
+		"drop without heating": {
+			samples:  []sample{{93, false}, {90, false}, {87, false}},
+			expected: []bool{false, false, false},
+		},
+		"slow cooling": {
+			// one degree every 5s never drops by 5 within the window
+			samples:  []sample{{93, true}, {92, true}, {91, true}, {90, true}, {89, true}, {88, true}, {87, true}},
+			expected: []bool{false, false, false, false, false, false, false},
+		},
+		"heating up": {
+			samples:  []sample{{60, true}, {70, true}, {80, true}},
+			expected: []bool{false, false, false},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := brewDetector{window: time.Second * 15, drop: 5}
+			start := time.Unix(0, 0)
+			for i, sample := range test.samples {
+				brewing := d.add(start.Add(time.Duration(i)*time.Second*5), sample.temp, sample.heating)
+				assert.Equal(t, test.expected[i], brewing, "sample %d", i)
+			}
+		})
+	}
+}
+
+func TestBrewing(t *testing.T) {
+	lines := []string{
+		"C1.23,120,120,093,0000,0\r\n",
+		"C1.23,120,120,087,0000,1\r\n",
+	}
+	collector := newCollector(&fakePort{lines: lines}, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	assert.Equal(t, float64(0), gaugeValue(t, gather(t, collector), "mara_x_brewing"))
+	clock.add(time.Second * 5)
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_brewing"))
+
+	collector = newCollector(&fakePort{lines: lines}, serial.OpenOptions{})
+	collector.brew.drop = 0
+	assert.NotContains(t, gather(t, collector), "mara_x_brewing")
+}
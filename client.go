@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// userAgentTransport sets the User-Agent header on every request before
+// passing it on to the next round tripper.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// newHTTPClient returns the client used for all outbound requests of the
+// exporter, identifying itself with userAgent.
+func newHTTPClient(userAgent string) *http.Client {
+	return &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &userAgentTransport{
+			userAgent: userAgent,
+			next:      http.DefaultTransport,
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClientUserAgent(t *testing.T) {
+	userAgents := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.UserAgent()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := newHTTPClient("mara-xporter/1.2.3")
+
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	pusher := newPusher(server.URL, "mara-x", newCollector(port, serial.OpenOptions{}), client)
+	require.NoError(t, pusher.Push())
+	assert.Equal(t, "mara-xporter/1.2.3", <-userAgents)
+
+	hook := newWebhook(server.URL, 0, 0, client)
+	hook.send(webhookEvent{Event: eventReady})
+	assert.Equal(t, "mara-xporter/1.2.3", <-userAgents)
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	assert.Equal(t, "mara-xporter/"+exporterVersion, flag.Lookup("user-agent").DefValue)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvColumns are the columns of the CSV file. New columns must be appended
+// at the end to keep existing files readable.
+var csvColumns = []string{
+	"timestamp", "version", "mode", "steam_temp", "steam_target_temp", "hx_temp", "ready_countdown", "heating",
+}
+
+// csvSink appends every status as a row to a CSV file. The rows are buffered
+// and only written once the buffer is full or the sink is closed.
+type csvSink struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+	closer io.Closer
+	// header is set if the header still has to be written before the first
+	// row.
+	header bool
+	now    func() time.Time
+}
+
+// openCSVSink opens the file at path for appending, it is created if it does
+// not exist. The header is only written to empty files.
+func openCSVSink(path string) (*csvSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open CSV file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to open CSV file: %w", err)
+	}
+	return newCSVSink(file, info.Size() == 0), nil
+}
+
+func newCSVSink(w io.WriteCloser, header bool) *csvSink {
+	return &csvSink{writer: csv.NewWriter(w), closer: w, header: header, now: time.Now}
+}
+
+// Emit appends the status as a row. The timestamp is the one of the line if
+// the firmware sent one and the time of the read otherwise.
+func (s *csvSink) Emit(status *maraXStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.header {
+		if err := s.writer.Write(csvColumns); err != nil {
+			slog.Error("error writing CSV header", "err", err)
+			return
+		}
+		s.header = false
+	}
+
+	timestamp := status.timestamp
+	if timestamp.IsZero() {
+		timestamp = s.now()
+	}
+	format := func(v uint16) string { return strconv.FormatUint(uint64(v), 10) }
+	row := []string{
+		timestamp.UTC().Format(time.RFC3339Nano),
+		status.version,
+		string(status.mode),
+		format(status.steamTemp),
+		format(status.steamTargetTemp),
+		format(status.hxTemp),
+		format(status.readyCountdown),
+		strconv.FormatBool(status.heating),
+	}
+	if err := s.writer.Write(row); err != nil {
+		slog.Error("error writing CSV row", "err", err)
+	}
+}
+
+// Close flushes the buffered rows and closes the file.
+func (s *csvSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		s.closer.Close()
+		return fmt.Errorf("unable to flush CSV file: %w", err)
+	}
+	return s.closer.Close()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.csv")
+	statuses := []*maraXStatus{
+		{mode: coffee, version: "1.23", steamTemp: 68, steamTargetTemp: 120, hxTemp: 54, readyCountdown: 820, heating: true},
+		{mode: steam, version: "1.23", steamTemp: 116, steamTargetTemp: 120, hxTemp: 95, timestamp: time.Unix(60, 0)},
+	}
+
+	sink, err := openCSVSink(path)
+	require.NoError(t, err)
+	sink.now = func() time.Time { return time.Unix(0, 0) }
+	for _, status := range statuses {
+		sink.Emit(status)
+	}
+	require.NoError(t, sink.Close())
+
+	// appending to the file does not repeat the header
+	sink, err = openCSVSink(path)
+	require.NoError(t, err)
+	sink.Emit(statuses[1])
+	require.NoError(t, sink.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "timestamp,version,mode,steam_temp,steam_target_temp,hx_temp,ready_countdown,heating\n"+
+		"1970-01-01T00:00:00Z,1.23,coffee,68,120,54,820,true\n"+
+		"1970-01-01T00:01:00Z,1.23,steam,116,120,95,0,false\n"+
+		"1970-01-01T00:01:00Z,1.23,steam,116,120,95,0,false\n", string(data))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// demoCycle is the duration after which the demo heats up again.
+	demoCycle = time.Minute * 30
+	// demoCountdown is the ready countdown at the start of each demo cycle.
+	demoCountdown = 900
+)
+
+// demoStatus returns a synthetic status oscillating over time. It is not
+// based on any real machine data.
+func (collector *maraXCollector) demoStatus() *maraXStatus {
+	now := collector.now()
+	if collector.demoStart.IsZero() {
+		collector.demoStart = now
+	}
+	elapsed := now.Sub(collector.demoStart)
+
+	// the machine heats up at the start of every cycle by counting down one
+	// step per second.
+	countdown := demoCountdown - int(elapsed%demoCycle/time.Second)
+	if countdown < 0 {
+		countdown = 0
+	}
+
+	// the boiler temperature swings around the target as the heating
+	// element is switched on and off.
+	swing := math.Sin(2 * math.Pi * elapsed.Seconds() / 90)
+
+	return &maraXStatus{
+		version:         "demo",
+		mode:            coffee,
+		steamTemp:       uint16(math.Round(120 + 4*swing)),
+		steamTargetTemp: 124,
+		hxTemp:          uint16(math.Round(93 + 3*math.Sin(2*math.Pi*elapsed.Seconds()/60))),
+		readyCountdown:  uint16(countdown),
+		heating:         swing < 0,
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDemoMode(t *testing.T) {
+	collector := newCollector(nil, serial.OpenOptions{})
+	collector.demo = true
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_demo_info"))
+	assert.Equal(t, "demo", labelValue(t, families, "mara_x_info", "version"))
+
+	hxTemps := map[float64]bool{}
+	countdowns := map[float64]bool{}
+	for i := 0; i < 10; i++ {
+		families := gather(t, collector)
+		hxTemps[gaugeValue(t, families, "mara_x_hx_temperature_celsius")] = true
+		countdowns[gaugeValue(t, families, "mara_x_ready_countdown")] = true
+		clock.add(time.Second * 7)
+	}
+	assert.Greater(t, len(hxTemps), 1)
+	assert.Greater(t, len(countdowns), 1)
+}
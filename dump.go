@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// dump writes the raw lines read from port to w until count lines have been
+// written or duration has passed, a limit of 0 is unlimited. Lines which are
+// not received within timeout are skipped, any other read error stops the
+// dump.
+func dump(w io.Writer, port io.ReadWriteCloser, count int, duration, timeout time.Duration, nonBlocking bool) error {
+	lines := newLineReader(port)
+	start := time.Now()
+	for written := 0; count == 0 || written < count; {
+		if duration > 0 && time.Since(start) >= duration {
+			return nil
+		}
+		line, err := lines.readLine(context.Background(), timeout, nonBlocking, false)
+		if errors.Is(err, errReadTimeout) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read from serial device: %w", err)
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		written++
+	}
+	return nil
+}
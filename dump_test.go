@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"\x00\xff\r\n",
+		"C1.23,069,120,055,0819,1\r\n",
+		"C1.23,070,120,056,0818,1\r\n",
+	}}
+	var out bytes.Buffer
+	require.NoError(t, dump(&out, port, 3, 0, time.Second, false))
+	assert.Equal(t, "C1.23,068,120,054,0820,1\r\n\x00\xff\r\nC1.23,069,120,055,0819,1\r\n", out.String())
+}
+
+func TestDumpDuration(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, dump(&out, &emptyPort{}, 0, time.Millisecond*50, time.Millisecond*10, true))
+	assert.Empty(t, out.String())
+}
+
+func TestDumpReadError(t *testing.T) {
+	var out bytes.Buffer
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	assert.Error(t, dump(&out, port, 0, 0, time.Second, false))
+	assert.Equal(t, "C1.23,068,120,054,0820,1\r\n", out.String())
+}
@@ -0,0 +1,66 @@
+package main
+
+import "time"
+
+// heatingSample is the state of the heating element at a point in time.
+type heatingSample struct {
+	time    time.Time
+	heating bool
+}
+
+// dutyWindow records heating samples to calculate the ratio of time the
+// heating element has been on within a sliding window. Each sample is
+// considered to last until the next one.
+type dutyWindow struct {
+	window  time.Duration
+	samples []heatingSample
+	// on is the total time the heating element has been on up to the last
+	// sample, it is not limited to the window.
+	on time.Duration
+}
+
+// add records a sample and evicts the samples which ended before the window.
+func (d *dutyWindow) add(t time.Time, heating bool) {
+	if n := len(d.samples); n > 0 && d.samples[n-1].heating {
+		d.on += t.Sub(d.samples[n-1].time)
+	}
+	d.samples = append(d.samples, heatingSample{time: t, heating: heating})
+
+	start := t.Add(-d.window)
+	evict := 0
+	for evict < len(d.samples)-1 && !d.samples[evict+1].time.After(start) {
+		evict++
+	}
+	d.samples = d.samples[evict:]
+}
+
+// ratio returns the ratio of time the heating element has been on within the
+// window ending at now. It returns false if there is no data for the window.
+func (d *dutyWindow) ratio(now time.Time) (float64, bool) {
+	start := now.Add(-d.window)
+
+	var total, on time.Duration
+	for i, sample := range d.samples {
+		from := sample.time
+		if from.Before(start) {
+			from = start
+		}
+		to := now
+		if i+1 < len(d.samples) {
+			to = d.samples[i+1].time
+		}
+		if !to.After(from) {
+			continue
+		}
+
+		total += to.Sub(from)
+		if sample.heating {
+			on += to.Sub(from)
+		}
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+	return float64(on) / float64(total), true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeatingDutyRatio(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,0000,0\r\n",
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,0000,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.heatingDuty.window = time.Minute
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	// a single sample does not cover any time yet
+	assert.NotContains(t, gather(t, collector), "mara_x_heating_duty_ratio")
+
+	steps := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{time.Second * 30, 1},
+		{time.Second * 30, 0.5},
+		{time.Second * 40, 2.0 / 3.0},
+	}
+	for _, step := range steps {
+		clock.add(step.elapsed)
+		assert.InDelta(t, step.expected, gaugeValue(t, gather(t, collector), "mara_x_heating_duty_ratio"), 0.0001)
+	}
+	assert.Len(t, collector.heatingDuty.samples, 3)
+}
+
+func TestEstimatedEnergy(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,0\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	assert.NotContains(t, gather(t, collector), "mara_x_estimated_energy_joules_total")
+	collector.boilerWatts = 1400
+
+	steps := []struct {
+		elapsed time.Duration
+		heating float64
+	}{
+		{time.Second * 10, 10},
+		{time.Second * 20, 10},
+		{time.Second * 5, 15},
+	}
+	for _, step := range steps {
+		clock.add(step.elapsed)
+		assert.Equal(t, 1400*step.heating, counterValue(t, gather(t, collector), "mara_x_estimated_energy_joules_total"))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statusView is the JSON representation of a maraXStatus.
+type statusView struct {
+	Version         string `json:"version"`
+	Mode            mode   `json:"mode"`
+	SteamTemp       uint16 `json:"steamTemp"`
+	SteamTargetTemp uint16 `json:"steamTargetTemp"`
+	HxTemp          uint16 `json:"hxTemp"`
+	ReadyCountdown  uint16 `json:"readyCountdown"`
+	Heating         bool   `json:"heating"`
+}
+
+func newStatusView(status *maraXStatus) statusView {
+	return statusView{
+		Version:         status.version,
+		Mode:            status.mode,
+		SteamTemp:       status.steamTemp,
+		SteamTargetTemp: status.steamTargetTemp,
+		HxTemp:          status.hxTemp,
+		ReadyCountdown:  status.readyCountdown,
+		Heating:         status.heating,
+	}
+}
+
+// broadcaster streams every published status to all connected clients as
+// Server-Sent Events. As a stream outlives the write timeout of the HTTP
+// server, the write deadline is moved by writeTimeout for every event
+// instead, or cleared if it is 0.
+type broadcaster struct {
+	mu           sync.Mutex
+	subscribers  map[chan *maraXStatus]struct{}
+	writeTimeout time.Duration
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subscribers: make(map[chan *maraXStatus]struct{})}
+}
+
+// Emit sends the status to all subscribers. Subscribers which have not
+// consumed the previous status yet miss this one instead of blocking.
+func (b *broadcaster) Emit(status *maraXStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
+}
+
+func (b *broadcaster) subscribe() chan *maraXStatus {
+	ch := make(chan *maraXStatus, 1)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan *maraXStatus) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+// responseControllerKey is the request context key of the response
+// controller set by withResponseController.
+type responseControllerKey struct{}
+
+// withResponseController passes the response controller of the writer
+// handler is called with in the request context. Writers wrapped by handler,
+// like the one of the promhttp instrumentation, do not unwrap to the
+// connection and setting deadlines on them fails.
+func withResponseController(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (b *broadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	deadlines, ok := r.Context().Value(responseControllerKey{}).(*http.ResponseController)
+	if !ok {
+		deadlines = http.NewResponseController(w)
+	}
+	extendDeadline := func() {
+		if deadlines == nil {
+			return
+		}
+		var deadline time.Time
+		if b.writeTimeout > 0 {
+			deadline = time.Now().Add(b.writeTimeout)
+		}
+		if err := deadlines.SetWriteDeadline(deadline); err != nil {
+			// the error is the same for every event, it is logged once
+			slog.Warn("unable to extend the write deadline of the events stream, it ends after the write timeout", "err", err)
+			deadlines = nil
+		}
+	}
+	extendDeadline()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case status := <-ch:
+			data, err := json.Marshal(newStatusView(status))
+			if err != nil {
+				return
+			}
+			extendDeadline()
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
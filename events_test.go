@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscriberCount returns the number of connected clients.
+func (b *broadcaster) subscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func TestEventsStream(t *testing.T) {
+	events := newBroadcaster()
+	server := httptest.NewServer(events)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	waitFor(t, func() bool { return events.subscriberCount() == 1 })
+	events.Emit(&maraXStatus{version: "1.23", mode: coffee, hxTemp: 54, heating: true})
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(line, "data: "))
+
+	var view statusView
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &view))
+	assert.Equal(t, statusView{Version: "1.23", Mode: coffee, HxTemp: 54, Heating: true}, view)
+
+	cancel()
+	waitFor(t, func() bool { return events.subscriberCount() == 0 })
+}
+
+func TestEventsStreamWriteTimeout(t *testing.T) {
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	events := collector.events
+	events.writeTimeout = time.Millisecond * 100
+	// served through the mux, the events stream is instrumented like the
+	// other endpoints
+	server := httptest.NewUnstartedServer(newMux(collector, prometheus.NewRegistry()))
+	server.Config.WriteTimeout = time.Millisecond * 100
+	server.Start()
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body := bufio.NewReader(resp.Body)
+
+	waitFor(t, func() bool { return events.subscriberCount() == 1 })
+	// the stream outlives the write timeout of the server
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 150)
+		events.Emit(&maraXStatus{version: "1.23", mode: coffee, hxTemp: 54})
+		line, err := body.ReadString('\n')
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(line, "data: "), line)
+		_, err = body.ReadString('\n')
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"runtime"
+)
+
+const (
+	exitFailure = 1
+	// exitDeviceUnavailable is returned if the serial device does not exist
+	// or may not be opened, so init systems and scripts can tell it apart.
+	exitDeviceUnavailable = 2
+)
+
+// deviceError is returned if the serial device could not be opened.
+type deviceError struct {
+	device string
+	err    error
+}
+
+func (e *deviceError) Error() string {
+	msg := fmt.Sprintf("unable to open serial device at %s: %v", e.device, e.err)
+	switch {
+	case errors.Is(e.err, fs.ErrNotExist):
+		msg += ", check that the machine is connected and -serial-dev is correct"
+	case errors.Is(e.err, fs.ErrPermission) && runtime.GOOS == "linux":
+		msg += ", the user running the exporter needs to be in the dialout group (usermod -aG dialout <user>)"
+	}
+	return msg
+}
+
+func (e *deviceError) Unwrap() error {
+	return e.err
+}
+
+// unavailable returns if the device is missing or may not be opened, as
+// opposed to other failures such as an unreachable serial bridge.
+func (e *deviceError) unavailable() bool {
+	return errors.Is(e.err, fs.ErrNotExist) || errors.Is(e.err, fs.ErrPermission)
+}
+
+// exitCode returns the exit code of the exporter failing with err.
+func exitCode(err error) int {
+	var deviceErr *deviceError
+	if errors.As(err, &deviceErr) && deviceErr.unavailable() {
+		return exitDeviceUnavailable
+	}
+	return exitFailure
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	_, notExist := os.Open(filepath.Join(t.TempDir(), "ttyUSB0"))
+	require.Error(t, notExist)
+	permission := &fs.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.EACCES}
+
+	for name, tc := range map[string]struct {
+		err  error
+		code int
+	}{
+		"device missing":       {err: &deviceError{device: "/dev/ttyUSB0", err: notExist}, code: exitDeviceUnavailable},
+		"permission denied":    {err: &deviceError{device: "/dev/ttyUSB0", err: permission}, code: exitDeviceUnavailable},
+		"wrapped":              {err: fmt.Errorf("startup: %w", &deviceError{device: "/dev/ttyUSB0", err: notExist}), code: exitDeviceUnavailable},
+		"bridge not reachable": {err: &deviceError{device: "tcp://localhost:1", err: syscall.ECONNREFUSED}, code: exitFailure},
+		"other":                {err: errors.New("invalid flag"), code: exitFailure},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.code, exitCode(tc.err))
+		})
+	}
+}
+
+func TestDeviceErrorMessage(t *testing.T) {
+	device := filepath.Join(t.TempDir(), "ttyUSB0")
+	_, err := newMaraXCollector(serial.Open, device)
+	require.Error(t, err)
+	assert.Equal(t, exitDeviceUnavailable, exitCode(err))
+	assert.Contains(t, err.Error(), "unable to open serial device at "+device)
+	assert.Contains(t, err.Error(), "-serial-dev")
+
+	err = &deviceError{device: device, err: &fs.PathError{Op: "open", Path: device, Err: syscall.EACCES}}
+	assert.Contains(t, err.Error(), "permission denied")
+}
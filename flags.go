@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validationErrors are all problems found when validating the flags.
+type validationErrors []error
+
+func (errs validationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid flags: " + strings.Join(msgs, "; ")
+}
+
+// validateFlags checks the values of all flags and returns every problem
+// found instead of stopping at the first one.
+func validateFlags() error {
+	var errs validationErrors
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	devices := serialDevices()
+	check(len(devices) > 0, "-serial-dev must not be empty")
+	check(len(devices) <= 1 || (*csvFile == "" && *replayFile == "" && !*dumpSerial),
+		"-csv-file, -replay-file and -dump only support a single -serial-dev")
+	seen := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		check(!seen[device], "-serial-dev contains %s more than once", device)
+		seen[device] = true
+		if addr, ok := tcpAddress(device); ok {
+			_, _, err := net.SplitHostPort(addr)
+			check(err == nil, "-serial-dev %s must be in the form %shost:port", device, tcpScheme)
+		}
+	}
+	check(*port > 0 && *port <= math.MaxUint16, "-port must be between 1 and %d, got %d", math.MaxUint16, *port)
+	if *pushgatewayURL != "" {
+		check(*pushJob != "", "-push-job must not be empty when pushing to a Pushgateway")
+		check(*pushInterval > 0, "-push-interval must be positive, got %s", *pushInterval)
+	}
+	// without scrapes only pushing to the Pushgateway or polling reads the
+	// machine, the readings polled are passed on by the push sinks
+	polling := *pollInterval > 0 && !*demoMode && *replayFile == ""
+	pushSink := *mqttBroker != "" || *influxURL != "" || *webhookURL != "" || *csvFile != ""
+	check(!*noHTTP || *pushgatewayURL != "" || (polling && pushSink),
+		"-no-http requires -pushgateway-url, or -mqtt-broker, -influx-url, -webhook-url or -csv-file with -poll-interval above 0, otherwise readings are never used")
+	check(*webhookHxMax <= math.MaxUint16, "-webhook-hx-max-temp must be at most %d, got %d", math.MaxUint16, *webhookHxMax)
+	check(*webhookCool >= 0, "-webhook-cooldown must not be negative, got %s", *webhookCool)
+	check(*modeDebounce >= 1, "-mode-debounce must be at least 1, got %d", *modeDebounce)
+	check(*logReadingsSample >= 1, "-log-readings-sample must be at least 1, got %d", *logReadingsSample)
+	check(*omitZeroCountdown >= 0, "-omit-zero-countdown-after must not be negative, got %d", *omitZeroCountdown)
+	check(*warmupReads >= 0, "-warmup-reads must not be negative, got %d", *warmupReads)
+	check(*smoothingAlpha >= 0 && *smoothingAlpha <= 1, "-smoothing-alpha must be between 0 and 1, got %v", *smoothingAlpha)
+	check(*minReadInterval >= 0, "-min-read-interval must not be negative, got %s", *minReadInterval)
+	check(*readRetries >= 0, "-read-retries must not be negative, got %d", *readRetries)
+	check(*httpReadTimeout >= 0, "-http-read-timeout must not be negative, got %s", *httpReadTimeout)
+	check(*httpWriteTimeout >= 0, "-http-write-timeout must not be negative, got %s", *httpWriteTimeout)
+	check(*httpIdleTimeout >= 0, "-http-idle-timeout must not be negative, got %s", *httpIdleTimeout)
+	check(*shutdownTimeout >= 0, "-shutdown-timeout must not be negative, got %s", *shutdownTimeout)
+	check(*boilerWatts >= 0, "-boiler-watts must not be negative, got %g", *boilerWatts)
+	check(!*demoMode || *replayFile == "", "-demo and -replay-file are mutually exclusive")
+	check(*reconnectMinInterval >= 0, "-reconnect-min-interval must not be negative, got %s", *reconnectMinInterval)
+	check(*countdownMax > 0 && *countdownMax <= math.MaxUint16, "-countdown-max must be between 1 and %d, got %d", math.MaxUint16, *countdownMax)
+	check(*rebootThreshold <= *countdownMax, "-reboot-threshold must not exceed -countdown-max %d, got %d", *countdownMax, *rebootThreshold)
+	check(!*runOnce || !*dumpSerial, "-once and -dump are mutually exclusive")
+	check(*dumpCount >= 0, "-dump-count must not be negative, got %d", *dumpCount)
+	check(*dumpDuration >= 0, "-dump-duration must not be negative, got %s", *dumpDuration)
+	check(*startupGrace >= 0, "-startup-grace must not be negative, got %s", *startupGrace)
+	check(*maxStaleness >= 0, "-max-staleness must not be negative, got %s", *maxStaleness)
+	check(*readTimeout > 0, "-read-timeout must be positive, got %s", *readTimeout)
+	check(*livenessTimeout > 0, "-liveness-timeout must be positive, got %s", *livenessTimeout)
+	check(*readinessWindow > 0, "-readiness-window must be positive, got %s", *readinessWindow)
+	check(*pollInterval >= 0, "-poll-interval must not be negative, got %s", *pollInterval)
+	check(!*skipOverlapping || *pollInterval == 0, "-skip-overlapping-scrapes requires -poll-interval 0, scrapes are served the background reading otherwise")
+	check(*minReadInterval == 0 || *pollInterval == 0, "-min-read-interval requires -poll-interval 0, scrapes are served the background reading otherwise")
+	check(*poweredGrace >= 0, "-powered-grace must not be negative, got %s", *poweredGrace)
+	check(*cycleMetrics >= 0, "-cycle-metrics must not be negative, got %d", *cycleMetrics)
+	check(*steamTempWarn <= math.MaxUint16, "-steam-temp-warn must be at most %d, got %d", math.MaxUint16, *steamTempWarn)
+	check(*steamTempCrit <= math.MaxUint16, "-steam-temp-crit must be at most %d, got %d", math.MaxUint16, *steamTempCrit)
+	check(*steamTempWarn == 0 || *steamTempCrit == 0 || *steamTempWarn < *steamTempCrit,
+		"-steam-temp-warn must be below -steam-temp-crit, got %d and %d", *steamTempWarn, *steamTempCrit)
+	check(*tempAnomalyMargin <= math.MaxUint16, "-temp-anomaly-margin must be at most %d, got %d", math.MaxUint16, *tempAnomalyMargin)
+	check(*brewHxDrop <= math.MaxUint16, "-brew-hx-drop must be at most %d, got %d", math.MaxUint16, *brewHxDrop)
+	check(*brewWindow > 0, "-brew-window must be positive, got %s", *brewWindow)
+	check(*heatingDutyWindow > 0, "-heating-duty-window must be positive, got %s", *heatingDutyWindow)
+	check(*units == unitsCelsius || *units == unitsFahrenheit, "-units must be %s or %s, got %q", unitsCelsius, unitsFahrenheit, *units)
+	check(!*tempMillidegrees || *units == unitsCelsius, "-temp-millidegrees can only be used with -units %s", unitsCelsius)
+	check(*logFormat == logFormatText || *logFormat == logFormatJSON, "-log-format must be %s or %s, got %q", logFormatText, logFormatJSON, *logFormat)
+	check(metricPrefixPattern.MatchString(*metricPrefix), "-metric-prefix must be a valid metric name, got %q", *metricPrefix)
+	check(*influxURL == "" || *influxBucket != "", "-influx-bucket must not be empty when writing to InfluxDB")
+	check((*authUser == "") == (*authPass == ""), "-auth-user and -auth-pass must be set together")
+	check((*tlsCert == "") == (*tlsKey == ""), "-tls-cert and -tls-key must be set together")
+	check(*serialBaud > 0, "-serial-baud must be positive, got %d", *serialBaud)
+	check(*serialDataBits >= 5 && *serialDataBits <= 8, "-serial-databits must be between 5 and 8, got %d", *serialDataBits)
+	check(*serialStopBits == 1 || *serialStopBits == 2, "-serial-stopbits must be 1 or 2, got %d", *serialStopBits)
+	check(*serialMinRead <= math.MaxUint8, "-serial-min-read must be at most %d, got %d", math.MaxUint8, *serialMinRead)
+	check(*serialMinRead > 0 || *nonBlocking, "-serial-min-read 0 requires -serial-nonblocking")
+	check(*flowControl == flowControlNone || *flowControl == flowControlRTSCTS,
+		"-flow-control must be %s or %s, got %q", flowControlNone, flowControlRTSCTS, *flowControl)
+	if *ntpServer != "" {
+		check(*ntpInterval > 0, "-ntp-interval must be positive, got %s", *ntpInterval)
+		check(*ntpMaxOffset >= 0, "-ntp-max-offset must not be negative, got %s", *ntpMaxOffset)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// rangeFlag is a flag of an inclusive range in the form min-max. It is unset
+// if empty.
+type rangeFlag struct {
+	min, max uint16
+	set      bool
+}
+
+func (f *rangeFlag) String() string {
+	if !f.set {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", f.min, f.max)
+}
+
+func (f *rangeFlag) Set(value string) error {
+	if value == "" {
+		*f = rangeFlag{}
+		return nil
+	}
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid range %q, expected min-max", value)
+	}
+	min, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid minimum of range %q: %w", value, err)
+	}
+	max, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid maximum of range %q: %w", value, err)
+	}
+	if min > max {
+		return fmt.Errorf("minimum of range %q is larger than the maximum", value)
+	}
+
+	*f = rangeFlag{min: uint16(min), max: uint16(max), set: true}
+	return nil
+}
+
+// contains returns whether the value is within the range.
+func (f *rangeFlag) contains(value uint16) bool {
+	return value >= f.min && value <= f.max
+}
+
+// bucketsFlag is a flag of comma separated, strictly increasing histogram
+// bucket upper bounds.
+type bucketsFlag []float64
+
+func (f *bucketsFlag) String() string {
+	bounds := make([]string, 0, len(*f))
+	for _, bound := range *f {
+		bounds = append(bounds, strconv.FormatFloat(bound, 'f', -1, 64))
+	}
+	return strings.Join(bounds, ",")
+}
+
+func (f *bucketsFlag) Set(value string) error {
+	var buckets bucketsFlag
+	for _, part := range strings.Split(value, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("invalid bucket %q: %w", part, err)
+		}
+		if n := len(buckets); n > 0 && bound <= buckets[n-1] {
+			return fmt.Errorf("buckets must be strictly increasing, %v follows %v", bound, buckets[n-1])
+		}
+		buckets = append(buckets, bound)
+	}
+
+	*f = buckets
+	return nil
+}
+
+// objectivesFlag is a flag of comma separated quantile:error pairs of the
+// objectives of a summary.
+type objectivesFlag map[float64]float64
+
+func (f objectivesFlag) String() string {
+	quantiles := make([]float64, 0, len(f))
+	for quantile := range f {
+		quantiles = append(quantiles, quantile)
+	}
+	sort.Float64s(quantiles)
+
+	pairs := make([]string, 0, len(quantiles))
+	for _, quantile := range quantiles {
+		pairs = append(pairs, strconv.FormatFloat(quantile, 'f', -1, 64)+":"+strconv.FormatFloat(f[quantile], 'f', -1, 64))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f objectivesFlag) Set(value string) error {
+	objectives := objectivesFlag{}
+	for _, part := range strings.Split(value, ",") {
+		quantile, allowed, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return fmt.Errorf("invalid objective %q, expected quantile:error", part)
+		}
+		q, err := strconv.ParseFloat(quantile, 64)
+		if err != nil || q <= 0 || q >= 1 {
+			return fmt.Errorf("invalid quantile %q, it has to be between 0 and 1", quantile)
+		}
+		e, err := strconv.ParseFloat(allowed, 64)
+		if err != nil || e <= 0 || e >= 1 {
+			return fmt.Errorf("invalid error %q of quantile %v, it has to be between 0 and 1", allowed, q)
+		}
+		objectives[q] = e
+	}
+
+	// the defaults are replaced instead of added to
+	for quantile := range f {
+		delete(f, quantile)
+	}
+	for quantile, e := range objectives {
+		f[quantile] = e
+	}
+	return nil
+}
+
+// lineFields are the names of the fields of a line in the order the machine
+// sends them by default. ignoredLineField marks a position which is skipped.
+var lineFields = []string{"mode_version", "steam_temp", "steam_target_temp", "hx_temp", "ready_countdown", "heating"}
+
+const ignoredLineField = "_"
+
+// lineFormatFlag is a flag of the comma separated field names in the order
+// they appear in a line. Every one of lineFields has to appear exactly once,
+// ignoredLineField any number of times.
+type lineFormatFlag []string
+
+func (f *lineFormatFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *lineFormatFlag) Set(value string) error {
+	known := make(map[string]bool, len(lineFields))
+	for _, field := range lineFields {
+		known[field] = true
+	}
+
+	var format lineFormatFlag
+	seen := make(map[string]bool, len(lineFields))
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field != ignoredLineField {
+			if !known[field] {
+				return fmt.Errorf("unknown field %q, known are %s and %s", field, strings.Join(lineFields, ", "), ignoredLineField)
+			}
+			if seen[field] {
+				return fmt.Errorf("field %s is set more than once", field)
+			}
+			seen[field] = true
+		}
+		format = append(format, field)
+	}
+	for _, field := range lineFields {
+		if !seen[field] {
+			return fmt.Errorf("field %s is missing", field)
+		}
+	}
+
+	*f = format
+	return nil
+}
+
+// scaleFields are the fields of maraXStatus which can be scaled.
+var scaleFields = map[string]bool{
+	"steam_temp":        true,
+	"steam_target_temp": true,
+	"hx_temp":           true,
+	"set_temp":          true,
+	"ready_countdown":   true,
+}
+
+// labelPattern matches valid label names, names starting with __ are
+// reserved for internal use.
+var labelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are the names of the labels the exporter uses itself, or
+// the Pushgateway and histograms use.
+var reservedLabels = map[string]bool{
+	"version": true, "mode": true, "serial_device": true, "baud": true, "alias": true,
+	"revision": true, "build_date": true, "goversion": true, "field": true, "reason": true,
+	"state": true, "cycle": true, "path": true, "code": true, "device": true,
+	"le": true, "quantile": true, "job": true, "instance": true,
+}
+
+// labelFlag is a repeatable flag of name=value pairs of constant labels.
+type labelFlag map[string]string
+
+func (f labelFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for name, value := range f {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f labelFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid label %q, expected name=value", value)
+	}
+
+	name := parts[0]
+	if !labelPattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	if reservedLabels[name] {
+		return fmt.Errorf("label name %q is already used by the exporter", name)
+	}
+	if _, ok := f[name]; ok {
+		return fmt.Errorf("label %s is set more than once", name)
+	}
+
+	f[name] = parts[1]
+	return nil
+}
+
+// scaleFlag is a repeatable flag of field=factor pairs. The value of a field
+// is multiplied by its factor before being exposed.
+type scaleFlag map[string]float64
+
+func (f scaleFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for field, factor := range f {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", field, factor))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f scaleFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid scale %q, expected field=factor", value)
+	}
+
+	field := parts[0]
+	if !scaleFields[field] {
+		return fmt.Errorf("unknown field %q to scale", field)
+	}
+
+	factor, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid factor for field %s: %w", field, err)
+	}
+	if factor == 0 {
+		return fmt.Errorf("factor for field %s must not be zero", field)
+	}
+
+	f[field] = factor
+	return nil
+}
+
+// scale returns the value of the field multiplied by its factor.
+func (f scaleFlag) scale(field string, value uint16) float64 {
+	factor, ok := f[field]
+	if !ok {
+		return float64(value)
+	}
+	return float64(value) * factor
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaleFlag(t *testing.T) {
+	scales := scaleFlag{}
+	assert.NoError(t, scales.Set("steam_temp=0.1"))
+	assert.NoError(t, scales.Set("hx_temp=1"))
+	assert.Error(t, scales.Set("hx_temp=0"))
+	assert.Error(t, scales.Set("pressure=2"))
+	assert.Error(t, scales.Set("steam_temp"))
+
+	assert.Equal(t, "hx_temp=1,steam_temp=0.1", scales.String())
+	assert.InDelta(t, 12.0, scales.scale("steam_temp", 120), 0.0001)
+	assert.Equal(t, float64(54), scales.scale("hx_temp", 54))
+	assert.Equal(t, float64(820), scales.scale("ready_countdown", 820))
+}
+
+func TestRangeFlag(t *testing.T) {
+	r := &rangeFlag{}
+	assert.Equal(t, "", r.String())
+	assert.NoError(t, r.Set("80-100"))
+	assert.Equal(t, "80-100", r.String())
+	assert.True(t, r.contains(80))
+	assert.True(t, r.contains(100))
+	assert.False(t, r.contains(101))
+
+	assert.Error(t, r.Set("100-80"))
+	assert.Error(t, r.Set("80"))
+	assert.Error(t, r.Set("a-100"))
+	assert.NoError(t, r.Set(""))
+	assert.False(t, r.set)
+}
+
+func TestBucketsFlag(t *testing.T) {
+	f := &bucketsFlag{}
+	require.NoError(t, f.Set("85, 90,92.5"))
+	assert.Equal(t, bucketsFlag{85, 90, 92.5}, *f)
+	assert.Equal(t, "85,90,92.5", f.String())
+
+	assert.Error(t, f.Set("90,85"))
+	assert.Error(t, f.Set("90,90"))
+	assert.Error(t, f.Set("90,hot"))
+	assert.Equal(t, bucketsFlag{85, 90, 92.5}, *f)
+}
+
+func TestObjectivesFlag(t *testing.T) {
+	f := objectivesFlag{0.5: 0.05, 0.9: 0.01}
+	require.NoError(t, f.Set("0.99:0.001, 0.5:0.01"))
+	assert.Equal(t, objectivesFlag{0.5: 0.01, 0.99: 0.001}, f)
+	assert.Equal(t, "0.5:0.01,0.99:0.001", f.String())
+
+	assert.Error(t, f.Set("0.5"))
+	assert.Error(t, f.Set("1:0.01"))
+	assert.Error(t, f.Set("0.5:0"))
+	assert.Error(t, f.Set("median:0.01"))
+	assert.Equal(t, objectivesFlag{0.5: 0.01, 0.99: 0.001}, f)
+}
+
+func TestLineFormatFlag(t *testing.T) {
+	f := &lineFormatFlag{}
+	require.NoError(t, f.Set("mode_version, hx_temp,steam_temp,steam_target_temp,_,ready_countdown,heating,_"))
+	assert.Equal(t, "mode_version,hx_temp,steam_temp,steam_target_temp,_,ready_countdown,heating,_", f.String())
+
+	assert.Error(t, f.Set("mode_version,steam_temp,steam_target_temp,hx_temp,ready_countdown"))
+	assert.Error(t, f.Set("mode_version,steam_temp,steam_target_temp,hx_temp,ready_countdown,heating,hx_temp"))
+	assert.Error(t, f.Set("mode_version,steam_temp,steam_target_temp,hx_temp,ready_countdown,heating,pressure"))
+	assert.Equal(t, 8, len(*f))
+}
+
+func TestLabelFlag(t *testing.T) {
+	f := labelFlag{}
+	require.NoError(t, f.Set("location=kitchen"))
+	require.NoError(t, f.Set("owner=team=coffee"))
+	assert.Equal(t, "location=kitchen,owner=team=coffee", f.String())
+
+	assert.Error(t, f.Set("location=office"))
+	assert.Error(t, f.Set("location"))
+	assert.Error(t, f.Set("1floor=2"))
+	assert.Error(t, f.Set("machine-serial=123"))
+	assert.Error(t, f.Set("__name__=x"))
+	assert.Error(t, f.Set("version=2"))
+}
+
+func TestValidateFlags(t *testing.T) {
+	assert.NoError(t, validateFlags())
+
+	defer func(p, debounce int, url string, interval time.Duration, flow string) {
+		*port = p
+		*modeDebounce = debounce
+		*pushgatewayURL = url
+		*pushInterval = interval
+		*flowControl = flow
+	}(*port, *modeDebounce, *pushgatewayURL, *pushInterval, *flowControl)
+
+	*port = 0
+	*modeDebounce = 0
+	*pushgatewayURL = "http://localhost:9091"
+	*pushInterval = -time.Second
+	*flowControl = "xonxoff"
+
+	err := validateFlags()
+	require.Error(t, err)
+	errs, ok := err.(validationErrors)
+	require.True(t, ok)
+	assert.Len(t, errs, 4)
+	assert.Contains(t, err.Error(), "-port")
+	assert.Contains(t, err.Error(), "-mode-debounce")
+	assert.Contains(t, err.Error(), "-push-interval")
+	assert.Contains(t, err.Error(), "-flow-control")
+}
+
+func TestValidateSerialSettings(t *testing.T) {
+	defer func(baud, dataBits, stopBits, minRead uint, nb bool) {
+		*serialBaud = baud
+		*serialDataBits = dataBits
+		*serialStopBits = stopBits
+		*serialMinRead = minRead
+		*nonBlocking = nb
+	}(*serialBaud, *serialDataBits, *serialStopBits, *serialMinRead, *nonBlocking)
+
+	*serialMinRead = 0
+	*nonBlocking = true
+	assert.NoError(t, validateFlags())
+
+	*serialBaud = 0
+	*serialDataBits = 9
+	*serialStopBits = 3
+	*serialMinRead = 0
+	*nonBlocking = false
+	err := validateFlags()
+	require.Error(t, err)
+	errs, ok := err.(validationErrors)
+	require.True(t, ok)
+	assert.Len(t, errs, 4)
+	assert.Contains(t, err.Error(), "-serial-baud")
+	assert.Contains(t, err.Error(), "-serial-databits")
+	assert.Contains(t, err.Error(), "-serial-stopbits")
+	assert.Contains(t, err.Error(), "-serial-min-read 0 requires")
+}
+
+func TestValidateMetricPrefix(t *testing.T) {
+	defer func() { *metricPrefix = defaultMetricPrefix }()
+
+	*metricPrefix = "cafe_1"
+	assert.NoError(t, validateFlags())
+
+	for _, prefix := range []string{"", "1cafe", "cafe-1", "cafe:1"} {
+		*metricPrefix = prefix
+		assert.Error(t, validateFlags(), prefix)
+	}
+}
+
+func TestValidateSerialDevices(t *testing.T) {
+	defer func(device, csv string) {
+		*serialDevice = device
+		*csvFile = csv
+	}(*serialDevice, *csvFile)
+
+	*serialDevice = "/dev/ttyUSB0, /dev/ttyUSB1"
+	assert.NoError(t, validateFlags())
+	assert.Equal(t, []string{"/dev/ttyUSB0", "/dev/ttyUSB1"}, serialDevices())
+
+	*csvFile = "readings.csv"
+	assert.Error(t, validateFlags())
+	*csvFile = ""
+
+	*serialDevice = "/dev/ttyUSB0,/dev/ttyUSB0"
+	assert.Error(t, validateFlags())
+	*serialDevice = ","
+	assert.Error(t, validateFlags())
+}
+
+func TestValidateTLS(t *testing.T) {
+	defer func() {
+		*tlsCert = ""
+		*tlsKey = ""
+	}()
+
+	*tlsCert = "cert.pem"
+	err := validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-tls-cert and -tls-key")
+
+	*tlsKey = "key.pem"
+	assert.NoError(t, validateFlags())
+}
+
+func TestValidateSteamTempThresholds(t *testing.T) {
+	defer func() {
+		*steamTempWarn = 0
+		*steamTempCrit = 0
+	}()
+
+	*steamTempWarn = 140
+	assert.NoError(t, validateFlags())
+
+	*steamTempCrit = 140
+	err := validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-steam-temp-warn must be below -steam-temp-crit")
+
+	*steamTempCrit = 160
+	assert.NoError(t, validateFlags())
+}
+
+func TestValidateCountdownThresholds(t *testing.T) {
+	defer func() {
+		*countdownMax = defaultCountdownMax
+		*rebootThreshold = defaultRebootThreshold
+	}()
+
+	*countdownMax = 1000
+	*rebootThreshold = 1000
+	assert.NoError(t, validateFlags())
+
+	*rebootThreshold = 1001
+	err := validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-reboot-threshold")
+}
+
+func TestValidateScrapeReadFlags(t *testing.T) {
+	defer func() {
+		*skipOverlapping = false
+		*minReadInterval = 0
+		*pollInterval = time.Second
+	}()
+
+	*skipOverlapping = true
+	*minReadInterval = time.Second * 5
+	err := validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-skip-overlapping-scrapes requires -poll-interval 0")
+	assert.Contains(t, err.Error(), "-min-read-interval requires -poll-interval 0")
+
+	*pollInterval = 0
+	assert.NoError(t, validateFlags())
+}
+
+func TestValidateNoHTTP(t *testing.T) {
+	defer func() {
+		*noHTTP = false
+		*pushgatewayURL = ""
+		*mqttBroker = ""
+		*influxURL = ""
+		*influxBucket = ""
+		*pollInterval = time.Second
+	}()
+
+	for name, test := range map[string]struct {
+		pushgateway, mqtt, influx string
+		pollInterval              time.Duration
+		valid                     bool
+	}{
+		"nothing to push":             {pollInterval: time.Second},
+		"pushgateway":                 {pushgateway: "http://localhost:9091", valid: true},
+		"mqtt":                        {mqtt: "tcp://localhost:1883", pollInterval: time.Second, valid: true},
+		"influx":                      {influx: "http://localhost:8086", pollInterval: time.Second, valid: true},
+		"mqtt without polling":        {mqtt: "tcp://localhost:1883"},
+		"influx without polling":      {influx: "http://localhost:8086"},
+		"mqtt and influx":             {mqtt: "tcp://localhost:1883", influx: "http://localhost:8086", pollInterval: time.Second, valid: true},
+		"pushgateway without polling": {pushgateway: "http://localhost:9091", mqtt: "tcp://localhost:1883", valid: true},
+	} {
+		*noHTTP = true
+		*pushgatewayURL = test.pushgateway
+		*mqttBroker = test.mqtt
+		*influxURL = test.influx
+		*influxBucket = "mara-x"
+		*pollInterval = test.pollInterval
+
+		err := validateFlags()
+		if test.valid {
+			assert.NoError(t, err, name)
+		} else if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), "-no-http requires", name)
+		}
+	}
+}
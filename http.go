@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// newHTTPServer returns the server listening on addr with the timeouts for
+// reading requests, writing responses and idle connections.
+func newHTTPServer(addr string, readTimeout, writeTimeout, idleTimeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+}
+
+// metricsHandler serves the metrics of the gatherer. If the request has
+// collect[] query parameters, only the metric families named by them are
+// served. With -openmetrics-units, clients accepting OpenMetrics get the
+// units of the metrics declared too.
+func metricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected := gatherer
+		if names := r.URL.Query()["collect[]"]; len(names) > 0 {
+			filtered := filteredGatherer{gatherer: gatherer, names: make(map[string]bool, len(names))}
+			for _, name := range names {
+				filtered.names[name] = true
+			}
+			selected = filtered
+		}
+
+		if *openMetricsUnits && expfmt.NegotiateIncludingOpenMetrics(r.Header) == expfmt.FmtOpenMetrics {
+			serveOpenMetricsUnits(w, selected)
+			return
+		}
+		promhttp.HandlerFor(selected, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// serveOpenMetricsUnits writes the metrics of the gatherer in the OpenMetrics
+// format with a UNIT line for every metric family with a known unit, which
+// the OpenMetrics encoder does not support itself.
+func serveOpenMetricsUnits(w http.ResponseWriter, gatherer prometheus.Gatherer) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		http.Error(w, "error gathering metrics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		if unit := metricUnit(family.GetName()); unit != "" {
+			buf.WriteString("# UNIT " + family.GetName() + " " + unit + "\n")
+		}
+		if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, family); err != nil {
+			http.Error(w, "error encoding metrics: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := expfmt.FinalizeOpenMetrics(&buf); err != nil {
+		http.Error(w, "error encoding metrics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+	buf.WriteTo(w)
+}
+
+// metricUnit returns the OpenMetrics unit of the metric family with the given
+// name, derived from the suffix of the name. It is empty if not known.
+func metricUnit(name string) string {
+	if strings.HasSuffix(name, "_celsius") {
+		return "celsius"
+	}
+	return ""
+}
+
+// filteredGatherer only returns the metric families with the given names.
+type filteredGatherer struct {
+	gatherer prometheus.Gatherer
+	names    map[string]bool
+}
+
+func (g filteredGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	filtered := families[:0]
+	for _, family := range families {
+		if g.names[family.GetName()] {
+			filtered = append(filtered, family)
+		}
+	}
+	return filtered, err
+}
+
+// changedGatherer omits the series whose value did not change since they were
+// last returned. The state is shared by all scrapes, so it only works with a
+// single scraper.
+type changedGatherer struct {
+	gatherer prometheus.Gatherer
+
+	mu sync.Mutex
+	// last holds the last returned value of each series by its name and
+	// labels.
+	last map[string]string
+}
+
+func newChangedGatherer(gatherer prometheus.Gatherer) *changedGatherer {
+	return &changedGatherer{gatherer: gatherer, last: make(map[string]string)}
+}
+
+func (g *changedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	changed := families[:0]
+	for _, family := range families {
+		metrics := family.Metric[:0]
+		for _, metric := range family.GetMetric() {
+			key := seriesKey(family.GetName(), metric)
+			value := metric.String()
+			if last, ok := g.last[key]; ok && last == value {
+				continue
+			}
+			g.last[key] = value
+			metrics = append(metrics, metric)
+		}
+		if len(metrics) > 0 {
+			family.Metric = metrics
+			changed = append(changed, family)
+		}
+	}
+	return changed, err
+}
+
+// seriesKey identifies a series by the name of its family and its labels.
+func seriesKey(name string, metric *dto.Metric) string {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, label := range metric.GetLabel() {
+		key.WriteString("," + label.GetName() + "=" + label.GetValue())
+	}
+	return key.String()
+}
+
+// basicAuth wraps next to require HTTP basic auth with user and password.
+func basicAuth(user, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		// both are compared in constant time to not leak which was wrong
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mara-xporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServe returns the function starting the HTTP server, serving
+// HTTPS with the certificate and key at certFile and keyFile if set.
+func listenAndServe(certFile, keyFile string) func(*http.Server) error {
+	return func(server *http.Server) error {
+		if certFile != "" {
+			return server.ListenAndServeTLS(certFile, keyFile)
+		}
+		return server.ListenAndServe()
+	}
+}
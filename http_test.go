@@ -0,0 +1,450 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHandlerFilter(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(newCollector(port, serial.OpenOptions{})))
+	server := httptest.NewServer(metricsHandler(reg))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "?collect[]=mara_x_hx_temperature_celsius")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Contains(t, string(body), "mara_x_hx_temperature_celsius 54")
+	assert.NotContains(t, string(body), "mara_x_steam_temperature_celsius")
+	assert.NotContains(t, string(body), "mara_x_info")
+
+	resp, err = server.Client().Get(server.URL)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Contains(t, string(body), "mara_x_hx_temperature_celsius 54")
+	assert.Contains(t, string(body), "mara_x_steam_temperature_celsius 68")
+}
+
+func TestMetricsHandlerOpenMetricsUnits(t *testing.T) {
+	*openMetricsUnits = true
+	defer func() { *openMetricsUnits = false }()
+
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(newCollector(port, serial.OpenOptions{})))
+	server := httptest.NewServer(metricsHandler(reg))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, string(expfmt.FmtOpenMetrics), resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "# UNIT mara_x_hx_temperature_celsius celsius\n")
+	assert.Contains(t, string(body), "mara_x_hx_temperature_celsius 54.0\n")
+	assert.NotContains(t, string(body), "# UNIT mara_x_info ")
+	assert.True(t, strings.HasSuffix(string(body), "# EOF\n"))
+
+	resp, err = server.Client().Get(server.URL)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Contains(t, string(body), "mara_x_hx_temperature_celsius 54")
+	assert.NotContains(t, string(body), "# UNIT")
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	server := newHTTPServer(":9100", time.Second, time.Second*2, time.Second*3)
+	assert.Equal(t, ":9100", server.Addr)
+	assert.Equal(t, time.Second, server.ReadTimeout)
+	assert.Equal(t, time.Second*2, server.WriteTimeout)
+	assert.Equal(t, time.Second*3, server.IdleTimeout)
+
+	// a client which never finishes its request is disconnected
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server = newHTTPServer("", time.Millisecond*50, 0, 0)
+	server.Handler = http.NotFoundHandler()
+	go server.Serve(listener)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /metrics HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second*5)))
+	_, err = ioutil.ReadAll(conn)
+	assert.NoError(t, err, "the server should close the connection before the deadline")
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	// borrow the certificate of a test server as its client trusts it
+	trusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer trusted.Close()
+	cert := trusted.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	server := &http.Server{Addr: addr, Handler: newMux(collector, prometheus.NewRegistry())}
+	served := make(chan error, 1)
+	go func() { served <- listenAndServe(certFile, keyFile)(server) }()
+	defer func() {
+		require.NoError(t, server.Close())
+		assert.Equal(t, http.ErrServerClosed, <-served)
+	}()
+
+	var resp *http.Response
+	waitFor(t, func() bool {
+		resp, err = trusted.Client().Get("https://" + addr + "/metrics")
+		return err == nil
+	})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, resp.TLS)
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	// the default registry comes with the Go and process collectors
+	assert.Contains(t, string(body), "\ngo_goroutines ")
+	assert.Contains(t, string(body), "\nprocess_resident_memory_bytes ")
+}
+
+func TestMetricsBasicAuth(t *testing.T) {
+	*authUser = "barista"
+	*authPass = "crema"
+	defer func() {
+		*authUser = ""
+		*authPass = ""
+	}()
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+	defer server.Close()
+
+	tests := map[string]struct {
+		user, password string
+		set            bool
+		expected       int
+	}{
+		"correct":        {user: "barista", password: "crema", set: true, expected: http.StatusOK},
+		"wrong password": {user: "barista", password: "ristretto", set: true, expected: http.StatusUnauthorized},
+		"wrong user":     {user: "guest", password: "crema", set: true, expected: http.StatusUnauthorized},
+		"missing":        {expected: http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+			require.NoError(t, err)
+			if test.set {
+				req.SetBasicAuth(test.user, test.password)
+			}
+			resp, err := server.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.expected, resp.StatusCode)
+			if test.expected == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="mara-xporter"`, resp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// the other endpoints stay open for health checks
+	resp, err := server.Client().Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPprof(t *testing.T) {
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	status := func() int {
+		server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+		defer server.Close()
+		resp, err := server.Client().Get(server.URL + "/debug/pprof/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, status())
+
+	*pprofEndpoints = true
+	defer func() { *pprofEndpoints = false }()
+	assert.Equal(t, http.StatusOK, status())
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	line := "C1.23,068,120,054,0820,1\r\n"
+	port := &fakePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+	server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+	defer server.Close()
+
+	status := func(path string) int {
+		resp, err := server.Client().Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, status("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/readyz"))
+
+	port.lines = []string{line}
+	gather(t, collector)
+	assert.Equal(t, http.StatusOK, status("/readyz"))
+
+	clock.add(time.Second * 10)
+	assert.Equal(t, http.StatusOK, status("/readyz"))
+	clock.add(time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, status("/readyz"))
+	assert.Equal(t, http.StatusOK, status("/healthz"))
+
+	port.lines = []string{line}
+	gather(t, collector)
+	assert.Equal(t, http.StatusOK, status("/readyz"))
+}
+
+func TestReadinessStartupGrace(t *testing.T) {
+	line := "C1.23,068,120,054,0820,1\r\n"
+	port := &fakePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+	collector.started = clock.now()
+	collector.startupGrace = time.Second * 30
+	server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+	defer server.Close()
+
+	get := func() (int, string) {
+		resp, err := server.Client().Get(server.URL + "/readyz")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	code, body := get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "warming up", body)
+
+	// a successful read does not end the grace early
+	port.lines = []string{line}
+	gather(t, collector)
+	code, body = get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "warming up", body)
+
+	clock.add(time.Second * 30)
+	code, body = get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "no successful read")
+
+	port.lines = []string{line}
+	gather(t, collector)
+	code, _ = get()
+	assert.Equal(t, http.StatusOK, code)
+}
+
+// blockPort blocks reads until released.
+type blockPort struct {
+	fakePort
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (p *blockPort) Read(b []byte) (int, error) {
+	p.once.Do(func() { close(p.started) })
+	<-p.release
+	return p.fakePort.Read(b)
+}
+
+func TestHealthWedgedRead(t *testing.T) {
+	port := &blockPort{
+		fakePort: fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}},
+		started:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+	server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+	defer server.Close()
+
+	status := func(path string) int {
+		resp, err := server.Client().Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gather(t, collector)
+	}()
+	<-port.started
+
+	// a read in progress is fine until it exceeds the timeout
+	assert.Equal(t, http.StatusOK, status("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/readyz"))
+	clock.add(time.Minute + time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, status("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/readyz"))
+
+	close(port.release)
+	<-done
+	assert.Equal(t, http.StatusOK, status("/healthz"))
+	assert.Equal(t, http.StatusOK, status("/readyz"))
+}
+
+func TestChangedGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	temp := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "temp"}, []string{"sensor"})
+	reg.MustRegister(temp)
+	temp.WithLabelValues("hx").Set(90)
+	temp.WithLabelValues("steam").Set(110)
+	gatherer := newChangedGatherer(reg)
+
+	series := func() map[string]float64 {
+		families, err := gatherer.Gather()
+		require.NoError(t, err)
+		result := map[string]float64{}
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				result[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+			}
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]float64{"hx": 90, "steam": 110}, series())
+	assert.Equal(t, map[string]float64{}, series())
+
+	temp.WithLabelValues("hx").Set(92)
+	assert.Equal(t, map[string]float64{"hx": 92}, series())
+	assert.Equal(t, map[string]float64{}, series())
+}
+
+func TestStatusHandler(t *testing.T) {
+	port := &fakePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+	server := httptest.NewServer(newMux(collector, prometheus.NewRegistry()))
+	defer server.Close()
+
+	get := func() (int, map[string]interface{}) {
+		resp, err := server.Client().Get(server.URL + "/status")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	code, body := get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "error")
+
+	port.lines = []string{"V1.23,116,120,095,0000,1\r\n"}
+	gather(t, collector)
+	code, body = get()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{
+		"version":         "1.23",
+		"mode":            "steam",
+		"steamTemp":       float64(116),
+		"steamTargetTemp": float64(120),
+		"hxTemp":          float64(95),
+		"readyCountdown":  float64(0),
+		"heating":         true,
+	}, body)
+}
+
+func TestHTTPRequestsTotal(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	server := httptest.NewServer(newMux(collector, reg))
+	defer server.Close()
+
+	for _, path := range []string{"/healthz", "/healthz", "/readyz", "/reset"} {
+		resp, err := server.Client().Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	requests := map[string]float64{}
+	for _, metric := range families[0].GetMetric() {
+		labels := map[string]string{}
+		for _, pair := range metric.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		requests[labels["path"]+" "+labels["code"]] = metric.GetCounter().GetValue()
+	}
+	assert.Equal(t, "mara_x_http_requests_total", families[0].GetName())
+	assert.Equal(t, map[string]float64{"/healthz 200": 2, "/readyz 503": 1, "/reset 405": 1}, requests)
+}
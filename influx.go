@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxMeasurement is the measurement every status is written to.
+const influxMeasurement = "mara_x"
+
+// influxWriter writes points in InfluxDB line protocol.
+type influxWriter interface {
+	Write(lines string) error
+}
+
+// influxSink writes every status as a point to InfluxDB. The writes happen
+// in the background so a slow or unreachable InfluxDB does not block
+// scrapes, errors are only logged.
+type influxSink struct {
+	writer influxWriter
+	now    func() time.Time
+}
+
+func newInfluxSink(writer influxWriter) *influxSink {
+	return &influxSink{writer: writer, now: time.Now}
+}
+
+func (s *influxSink) Emit(status *maraXStatus) {
+	line := influxLine(status, s.now())
+	go func() {
+		if err := s.writer.Write(line); err != nil {
+			slog.Warn("error writing to InfluxDB", "err", err)
+		}
+	}()
+}
+
+// influxLine serializes the status into a line of InfluxDB line protocol.
+// The mode and version are tags, the optional fields are left out if the
+// firmware did not report them. The point is timestamped with the timestamp
+// of the line if it had one, otherwise with now.
+func influxLine(status *maraXStatus, now time.Time) string {
+	format := func(v uint16) string { return strconv.FormatUint(uint64(v), 10) + "i" }
+	fields := []string{
+		"steam_temp=" + format(status.steamTemp),
+		"steam_target_temp=" + format(status.steamTargetTemp),
+		"hx_temp=" + format(status.hxTemp),
+		"ready_countdown=" + format(status.readyCountdown),
+		"heating=" + strconv.FormatBool(status.heating),
+	}
+	if status.setTemp != nil {
+		fields = append(fields, "set_temp="+format(*status.setTemp))
+	}
+	if status.pressure != nil {
+		fields = append(fields, "pressure="+strconv.FormatFloat(*status.pressure, 'f', -1, 64))
+	}
+
+	timestamp := now
+	if !status.timestamp.IsZero() {
+		timestamp = status.timestamp
+	}
+	return fmt.Sprintf("%s,mode=%s,version=%s %s %d\n",
+		influxMeasurement, influxEscape(string(status.mode)), influxEscape(status.version),
+		strings.Join(fields, ","), timestamp.UnixNano(),
+	)
+}
+
+// influxTagEscaper escapes the characters with a meaning in tag values.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func influxEscape(value string) string {
+	return influxTagEscaper.Replace(value)
+}
+
+// influxHTTPWriter writes points to the bucket of an InfluxDB 2 server with
+// its HTTP API.
+type influxHTTPWriter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newInfluxHTTPWriter(baseURL, org, bucket, token string, client *http.Client) *influxHTTPWriter {
+	query := url.Values{"bucket": {bucket}, "precision": {"ns"}}
+	if org != "" {
+		query.Set("org", org)
+	}
+	return &influxHTTPWriter{
+		url:    strings.TrimRight(baseURL, "/") + "/api/v2/write?" + query.Encode(),
+		token:  token,
+		client: client,
+	}
+}
+
+func (w *influxHTTPWriter) Write(lines string) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader([]byte(lines)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeInfluxWriter struct {
+	lines chan string
+}
+
+func (w *fakeInfluxWriter) Write(lines string) error {
+	w.lines <- lines
+	return nil
+}
+
+func TestInfluxLine(t *testing.T) {
+	setTemp := uint16(93)
+	pressure := 9.5
+	status := &maraXStatus{
+		mode:            steam,
+		version:         "1.23",
+		steamTemp:       116,
+		steamTargetTemp: 120,
+		hxTemp:          95,
+		readyCountdown:  0,
+		heating:         true,
+	}
+	now := time.Unix(1600000000, 0)
+
+	assert.Equal(t,
+		"mara_x,mode=steam,version=1.23 steam_temp=116i,steam_target_temp=120i,hx_temp=95i,ready_countdown=0i,heating=true 1600000000000000000\n",
+		influxLine(status, now),
+	)
+
+	status.setTemp = &setTemp
+	status.pressure = &pressure
+	status.version = "1.2 beta"
+	status.timestamp = time.Unix(1700000000, 0)
+	assert.Equal(t,
+		`mara_x,mode=steam,version=1.2\ beta steam_temp=116i,steam_target_temp=120i,hx_temp=95i,ready_countdown=0i,heating=true,set_temp=93i,pressure=9.5 1700000000000000000`+"\n",
+		influxLine(status, now),
+	)
+}
+
+func TestInfluxSink(t *testing.T) {
+	writer := &fakeInfluxWriter{lines: make(chan string, 1)}
+	sink := newInfluxSink(writer)
+	sink.now = func() time.Time { return time.Unix(0, 42) }
+	sink.Emit(&maraXStatus{mode: coffee, version: "1.23", hxTemp: 90})
+	assert.Equal(t,
+		"mara_x,mode=coffee,version=1.23 steam_temp=0i,steam_target_temp=0i,hx_temp=90i,ready_countdown=0i,heating=false 42\n",
+		<-writer.lines,
+	)
+}
+
+func TestInfluxHTTPWriter(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := newInfluxHTTPWriter(server.URL+"/", "home", "coffee", "secret", server.Client())
+	require.NoError(t, writer.Write("mara_x hx_temp=90i 42\n"))
+	assert.Equal(t, "/api/v2/write", got.URL.Path)
+	assert.Equal(t, "coffee", got.URL.Query().Get("bucket"))
+	assert.Equal(t, "home", got.URL.Query().Get("org"))
+	assert.Equal(t, "ns", got.URL.Query().Get("precision"))
+	assert.Equal(t, "Token secret", got.Header.Get("Authorization"))
+	assert.Equal(t, "mara_x hx_temp=90i 42\n", string(body))
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	assert.Error(t, writer.Write("mara_x hx_temp=90i 42\n"))
+}
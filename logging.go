@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"os"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging configures the default logger to write to w. The text format
+// keeps logging through the log package, the json format writes one JSON
+// object per line instead, including for the remaining users of the log
+// package. Debug records are only logged if debug is set.
+func setupLogging(w io.Writer, format string, debug bool) {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+
+	if format == logFormatJSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+		return
+	}
+	log.SetOutput(w)
+	slog.SetLogLoggerLevel(level)
+}
+
+// fatal logs the error and exits with its exitCode.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(exitCode(err))
+}
+
+// statusAttrs returns the fields of the status as log attributes.
+func statusAttrs(status *maraXStatus) []any {
+	return []any{
+		"version", status.version,
+		"mode", status.mode,
+		"steam_temp", status.steamTemp,
+		"steam_target_temp", status.steamTargetTemp,
+		"hx_temp", status.hxTemp,
+		"ready_countdown", status.readyCountdown,
+		"heating", status.heating,
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONLogging(t *testing.T) {
+	defer func(logger *slog.Logger, flags int) {
+		slog.SetDefault(logger)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}(slog.Default(), log.Flags())
+
+	var logs bytes.Buffer
+	setupLogging(&logs, logFormatJSON, false)
+
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	collector.update(nil, errors.New("device gone"))
+	collector.update(nil, &parseError{err: errors.New("invalid heating field")})
+	// debug records are dropped
+	collector.update(&maraXStatus{mode: coffee}, nil)
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	var records []map[string]interface{}
+	for _, line := range lines {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		records = append(records, record)
+	}
+
+	assert.Equal(t, "WARN", records[0]["level"])
+	assert.Equal(t, "error reading from serial port", records[0]["msg"])
+	assert.Equal(t, readErrorIO, records[0]["reason"])
+	assert.Equal(t, "device gone", records[0]["err"])
+	assert.Equal(t, "ERROR", records[1]["level"])
+	assert.Equal(t, "invalid heating field", records[1]["err"])
+}
+
+// TestNoUnstructuredLogging makes sure everything is logged through slog, so
+// -log-format json does not end up with plain text lines. Only setting up
+// the standard logger in setupLogging is left to the log package.
+func TestNoUnstructuredLogging(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		require.NoError(t, err)
+		logName := ""
+		for _, spec := range file.Imports {
+			if spec.Path.Value == `"log"` {
+				logName = "log"
+				if spec.Name != nil {
+					logName = spec.Name.Name
+				}
+			}
+		}
+		if logName == "" {
+			continue
+		}
+		ast.Inspect(file, func(node ast.Node) bool {
+			selector, ok := node.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := selector.X.(*ast.Ident); ok && pkg.Name == logName {
+				for _, prefix := range []string{"Print", "Fatal", "Panic"} {
+					assert.False(t, strings.HasPrefix(selector.Sel.Name, prefix),
+						"%s: use slog instead of log.%s", fset.Position(selector.Pos()), selector.Sel.Name)
+				}
+			}
+			return true
+		})
+	}
+}
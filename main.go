@@ -2,15 +2,30 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/jacobsa/go-serial/serial"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,165 +34,2269 @@ import (
 
 type maraXCollector struct {
 	info            *prometheus.Desc
-	steamTemp       *prometheus.Desc
-	steamTargetTemp *prometheus.Desc
-	hxTemp          *prometheus.Desc
+	steamTemp       temperatureMetric
+	steamTargetTemp temperatureMetric
+	hxTemp          temperatureMetric
+	steamSmoothed   temperatureMetric
+	hxSmoothed      temperatureMetric
 	readyCountdown  *prometheus.Desc
 	heating         *prometheus.Desc
 
+	hxUnchangedReads      *prometheus.Desc
+	readSuccessRatio      *prometheus.Desc
+	readySeconds          *prometheus.Desc
+	modeGauge             *prometheus.Desc
+	dataStale             *prometheus.Desc
+	secondsSinceReady     *prometheus.Desc
+	ready                 *prometheus.Desc
+	readyCountdownInitial *prometheus.Desc
+	heatUpDuration        *prometheus.Desc
+	notHeatingSeconds     *prometheus.Desc
+	heatingOnSeconds      *prometheus.Desc
+	tempAnomaly           *prometheus.Desc
+	tempAlertLevel        *prometheus.Desc
+	configuredReadTimeout *prometheus.Desc
+	configPollInterval    *prometheus.Desc
+	configReadRetries     *prometheus.Desc
+	configMaxStaleness    *prometheus.Desc
+	hxTempModeAvg         *prometheus.Desc
+	goroutines            *prometheus.Desc
+	lineTimestamp         *prometheus.Desc
+	lastReadTimestamp     *prometheus.Desc
+	demoInfo              *prometheus.Desc
+	setTemp               temperatureMetric
+	brewPressure          *prometheus.Desc
+	heatingDutyRatio      *prometheus.Desc
+	brewing               *prometheus.Desc
+	estimatedEnergy       *prometheus.Desc
+	readySecondsEstimate  *prometheus.Desc
+	buildInfo             *prometheus.Desc
+	startupCheckPassed    *prometheus.Desc
+	baudMismatch          *prometheus.Desc
+	duplicateLines        *prometheus.Desc
+	state                 *prometheus.Desc
+	powered               *prometheus.Desc
+	scrapeInterval        *prometheus.Desc
+	unexpectedVersion     *prometheus.Desc
+	reboots               *prometheus.Desc
+	modeChanges           *prometheus.Desc
+	heatingToggles        *prometheus.Desc
+	fastHeatingProgress   *prometheus.Desc
+	serialConnected       *prometheus.Desc
+	up                    *prometheus.Desc
+	scrapesTotal          *prometheus.Desc
+	scrapesSkipped        *prometheus.Desc
+	sensorFault           *prometheus.Desc
+
+	parseRetries  prometheus.Counter
+	reads         prometheus.Counter
+	readErrors    *prometheus.CounterVec
+	heatingOn     prometheus.Histogram
+	hxTemps       prometheus.Histogram
+	parseDuration prometheus.Histogram
+	readDuration  prometheus.Histogram
+	readLatency   prometheus.Summary
+
 	serialPort io.ReadWriteCloser
 	serialOpts serial.OpenOptions
+	// open is used to reopen the serial port.
+	open opener
+	// readTimeout is the time to wait for a line from the serial port.
+	readTimeout time.Duration
+	// readRetries is the number of times a failed read is retried before
+	// the status counts as failed.
+	readRetries int
+	// reader buffers reads from serial ports supporting read deadlines. It
+	// is reset whenever the port is reopened.
+	reader *bufio.Reader
+	// lines reads from serial ports without read deadlines, it is replaced
+	// whenever the port is.
+	lines *lineReader
+	// resync is set while the first line after opening the port has not
+	// been read yet, it is discarded as the port may have been opened in the
+	// middle of a line. It is only set on opening if resyncOnOpen is set.
+	resync       bool
+	resyncOnOpen bool
+	// readErrorsInRow counts the consecutive read errors, reconnectAt and
+	// reconnectBackoff schedule the attempts to reopen the serial port once
+	// it is disconnected. lastReconnect is the time of the last attempt,
+	// attempts are at least reconnectMinInterval apart even if the port keeps
+	// disconnecting right after being reopened. They are only used by the
+	// reader.
+	readErrorsInRow      int
+	reconnectAt          time.Time
+	reconnectBackoff     time.Duration
+	lastReconnect        time.Time
+	reconnectMinInterval time.Duration
+	// twoLineStatus is set if the firmware splits each status across two
+	// lines.
+	twoLineStatus bool
+	// nonBlocking is set if the serial port returns from reads without data
+	// instead of blocking until data is available.
+	nonBlocking bool
+	// sinks receive every status read.
+	sinks []Sink
+	// events streams every status read to connected clients, it is one of
+	// the sinks.
+	events *broadcaster
+	// exposeGoroutines enables the goroutines metric.
+	exposeGoroutines bool
+	// exposeState enables the state metric.
+	exposeState bool
+	// heatingHistogram enables the histogram of the heating on-durations.
+	heatingHistogram bool
+	// hxTempHistogram enables the histogram of the hx temperatures.
+	hxTempHistogram bool
+	// smoothSteam and smoothHx average the exposed temperatures, they are
+	// only exposed if smoothing is set.
+	smoothing   bool
+	smoothSteam movingAverage
+	smoothHx    movingAverage
+	// exposeScrapeInterval enables the observed scrape interval metric.
+	exposeScrapeInterval bool
+	// logReadingsSample logs every nth reading, logging of readings is
+	// disabled if 0. readings counts the readings to sample them.
+	logReadingsSample int
+	readings          uint64
+	// debug enables logging of additional information to debug problems.
+	debug bool
+	// scales are applied to the values of the fields when exposing them.
+	scales scaleFlag
+	// millidegrees exposes the temperatures as integer millidegrees.
+	millidegrees bool
+	// fahrenheit exposes the temperatures in degrees fahrenheit instead of
+	// celsius.
+	fahrenheit bool
+	// consolidated adds the serial details and the alias of the machine to
+	// the info metric.
+	consolidated bool
+	alias        string
+	// demo generates synthetic statuses instead of reading the serial port,
+	// starting at demoStart.
+	demo      bool
+	demoStart time.Time
+	// now returns the current time, it can be replaced in tests.
+	now func() time.Time
+	// started is when the collector was created, /readyz fails during the
+	// startupGrace after it.
+	started      time.Time
+	startupGrace time.Duration
+	// polling is set if statuses are read in the background instead of on
+	// every scrape, every pollInterval.
+	polling      bool
+	pollInterval time.Duration
+	// minReadInterval is the minimum time between two reads on scrapes, the
+	// scrapes within it serve the result of the last read instead.
+	minReadInterval time.Duration
+	// skipOverlapping is set if a scrape serves the latest reading instead
+	// of waiting for a read of another scrape still in progress.
+	skipOverlapping bool
+	// readMu serializes the reads from the serial port, concurrent scrapes
+	// would otherwise interleave their reads and corrupt the lines. It is
+	// not held together with mu so a slow read does not block the HTTP
+	// handlers.
+	readMu sync.Mutex
+
+	// mu protects the state below which is tracked across scrapes.
+	mu sync.Mutex
+	// previous is the last status that was successfully read at previousTime.
+	// lastReadFailed is set if the read after it failed.
+	previous       *maraXStatus
+	previousTime   time.Time
+	lastReadFailed bool
+	// lastReadAttempt is the time of the last read on a scrape, successful
+	// or not.
+	lastReadAttempt time.Time
+	// skippedScrapes counts the scrapes which found a read in progress.
+	skippedScrapes uint64
+	// lastReadTime is the time the last line was successfully parsed, unlike
+	// previousTime it is also set for readings dropped as sensor faults.
+	lastReadTime time.Time
+	// readStarted is the time the read in progress started, it is zero while
+	// no read is in progress.
+	readStarted time.Time
+	// disconnected is set while the serial port is considered disconnected.
+	disconnected bool
+	// lastScrape is the time of the previous scrape, scrapes counts them.
+	lastScrape time.Time
+	scrapes    uint64
+	// failedSelfMetrics is set if the self-metrics are sent even when the
+	// last read failed.
+	failedSelfMetrics bool
+	// poweredGrace is the time since previousTime after which the machine
+	// is considered to be powered off.
+	poweredGrace time.Duration
+	// maxStaleness is how long the previous status is still sent after
+	// reads started failing, it is not sent at all if 0.
+	maxStaleness time.Duration
+	// hxUnchanged is the number of consecutive readings where hxTemp did not
+	// change. While polling these are the polls, not the scrapes.
+	hxUnchanged uint64
+	// readSuccesses and readFailures count the outcome of all reads from the
+	// serial port since startup.
+	readSuccesses uint64
+	readFailures  uint64
+	// readyDuration is the total time the machine has been ready.
+	readyDuration time.Duration
+	// lastReady is the time of the last reading in which the machine was
+	// ready, it is zero if it has not been ready since startup.
+	lastReady time.Time
+	// notHeatingDuration is the total time the heating element has been off,
+	// it is only exposed if trackNotHeating is set.
+	notHeatingDuration time.Duration
+	trackNotHeating    bool
+	// mode is the mode the machine is considered to be in. A different mode
+	// is only taken over once it has been read for modeDebounce consecutive
+	// scrapes, pendingMode and pendingModeScrapes track such a change.
+	mode               mode
+	modeDebounce       int
+	pendingMode        mode
+	pendingModeScrapes int
+	// zeroCountdownScrapes is the number of consecutive scrapes the ready
+	// countdown has been 0. The countdown is not exposed anymore once this
+	// exceeds omitZeroCountdown, unless that is 0.
+	zeroCountdownScrapes int
+	omitZeroCountdown    int
+	// unexpectedVersionChanges counts the version changes without a reboot.
+	unexpectedVersionChanges uint64
+	// rebootCount counts the reboots of the machine, detected by the countdown
+	// rising to at least rebootThreshold. countdownMax is the countdown fast
+	// heating starts at.
+	rebootCount     uint64
+	rebootThreshold uint16
+	countdownMax    uint16
+	// modeChangeCount counts the changes of the debounced mode and
+	// heatingToggleCount those of the heating between consecutive statuses.
+	modeChangeCount    uint64
+	heatingToggleCount uint64
+	// countdownInitial is the highest ready countdown read since the start
+	// of the current or last heating cycle, it is 0 until the first cycle.
+	countdownInitial uint16
+	// countdownFrom is the countdown read at countdownSince, from which on
+	// it has only been decreasing.
+	countdownFrom  uint16
+	countdownSince time.Time
+	// cycles is the number of heating cycles started since startup, the
+	// current one started at cycleStart. heatUps holds the heat-up
+	// durations of the last keepCycles cycles, it is disabled if 0.
+	cycles     uint64
+	cycleStart time.Time
+	heatUps    []heatUp
+	keepCycles int
+	// hxTempSums and hxTempCounts hold the sum and number of heat exchanger
+	// temperatures read per mode to calculate the average.
+	hxTempSums   map[mode]float64
+	hxTempCounts map[mode]uint64
+	// heatingDuty records the recent states of the heating element.
+	heatingDuty dutyWindow
+	// brew detects shots being pulled from the recent hx temperatures,
+	// brewDetected is the outcome at the previous read.
+	brew         brewDetector
+	brewDetected bool
+	// tempAnomalyMargin is how far the steam temperature may exceed its
+	// target before the reading is flagged as an anomaly in tempAnomalous,
+	// the check is disabled if 0.
+	tempAnomalyMargin uint16
+	tempAnomalous     bool
+	// steamTempWarn and steamTempCrit are the steam temperatures from which
+	// tempAlert is alertWarn or alertCrit, each is disabled if 0.
+	steamTempWarn uint16
+	steamTempCrit uint16
+	tempAlert     float64
+	// boilerWatts is the power of the heating element, the energy estimate
+	// is exposed if it is set.
+	boilerWatts float64
+	// heatingSince is the time the heating element was turned on, it is
+	// only valid while it is on.
+	heatingSince time.Time
+	// sensorRanges are the plausible ranges of the fields by name, a status
+	// with a field outside of its range is dropped. sensorFaults holds the
+	// result of validating the last status, lastReadFaulty is set if it was
+	// dropped.
+	sensorRanges   map[string]rangeFlag
+	sensorFaults   map[string]bool
+	lastReadFaulty bool
+	// expectHxRange is checked against the first reading, startupCheck holds
+	// the result once checked.
+	expectHxRange rangeFlag
+	startupCheck  *bool
+	// baud guesses from the lines read whether the baud rate is wrong.
+	baud baudDetector
+	// lastLine is the last raw line read, duplicateLineCount counts the
+	// lines which were identical to the one before.
+	lastLine           []byte
+	duplicateLineCount uint64
+}
+
+// heatUp is the time it took the machine to get ready in a heating cycle.
+type heatUp struct {
+	cycle    uint64
+	duration time.Duration
+}
+
+// maraXStatus is all the data returned by the Mara X serial UART port.
+type maraXStatus struct {
+	// version is the firmware version of the thing.
+	version string
+	// mode is the mode the machine is in. C for coffee priority, V for vapour (steam) priority.
+	mode mode
+	// steamTemp is the current steam temperature
+	steamTemp uint16
+	// steamTargetTemp is the steam target temperature it wants to reach
+	steamTargetTemp uint16
+	// hxTemp is the current temperature of the heat exchanger
+	hxTemp uint16
+	// readyCountdown shows if the machine is in "fast heating" mode. If so, it
+	// will start somewhere at 1500 and eventually end up at 0 once it's done.
+	readyCountdown uint16
+	// heating indicates whether the heating element is on or off.
+	heating bool
+	// setTemp is the set coffee temperature, it is nil if the firmware does
+	// not report it.
+	setTemp *uint16
+	// pressure is the brew pressure in bar reported by machines modded with
+	// a pressure sensor, it is nil if not reported.
+	pressure *float64
+	// timestamp is the time the line was prefixed with by the firmware, it
+	// is zero if the line did not have a timestamp.
+	timestamp time.Time
+}
+
+type mode string
+
+const (
+	coffee mode = "coffee"
+	steam  mode = "steam"
+
+	coffeeMode = "C"
+	steamMode  = "V"
+
+	// firstLineFields is the number of fields of the first line of a
+	// status split across two lines.
+	firstLineFields = 4
+
+	numericCoffeeMode = "0"
+	numericSteamMode  = "1"
+
+	// unknownVersion is reported for lines without a version if allowed.
+	unknownVersion = "unknown"
+
+	defaultReadTimeout = time.Second
+
+	// alertOK, alertWarn and alertCrit are the levels of the steam
+	// temperature alert.
+	alertOK   = 0
+	alertWarn = 1
+	alertCrit = 2
+	// defaultReadRetries is the number of times a failed read is retried
+	// within a scrape, after waiting for readRetryBackoff if the read itself
+	// and not parsing the line failed.
+	defaultReadRetries = 2
+	readRetryBackoff   = time.Millisecond * 10
+
+	// defaultCountdownMax is the ready countdown fast heating starts at and
+	// defaultRebootThreshold the countdown a rising countdown has to reach
+	// to be considered a reboot.
+	defaultCountdownMax    = 1500
+	defaultRebootThreshold = 1000
+
+	// readErrorTimeout, readErrorParse and readErrorIO are the reasons of
+	// the read errors metric.
+	readErrorTimeout = "timeout"
+	readErrorParse   = "parse"
+	readErrorIO      = "io"
+
+	// unitsCelsius and unitsFahrenheit are the values of -units.
+	unitsCelsius    = "celsius"
+	unitsFahrenheit = "fahrenheit"
+
+	// flowControlNone and flowControlRTSCTS are the values of -flow-control.
+	// Software flow control with XON/XOFF is not supported by the serial
+	// library.
+	flowControlNone   = "none"
+	flowControlRTSCTS = "rtscts"
+
+	// reconnectAfterErrors is the number of consecutive read errors after
+	// which the serial port is reopened. minReconnectBackoff and
+	// maxReconnectBackoff bound the time between two attempts.
+	reconnectAfterErrors = 3
+	minReconnectBackoff  = time.Second
+	maxReconnectBackoff  = time.Minute
+
+	// warmupTimeout is the overall time the warm-up may take.
+	warmupTimeout = time.Second * 10
+
+	// nonBlockingPollInterval is the time to wait before reading again from a
+	// non-blocking serial port that returned without data.
+	nonBlockingPollInterval = time.Millisecond * 10
+)
+
+// versionPattern matches the firmware versions, like 1.23.
+var versionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+const (
+	// defaultMetricPrefix is the default prefix of the flat metric names
+	// and structuredNamespace the namespace of the structured ones.
+	defaultMetricPrefix = "mara_x"
+	structuredNamespace = "marax"
+)
+
+// metricPrefixPattern matches valid metric name prefixes. Colons are allowed
+// in metric names but reserved for recording rules.
+var metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	serialDevice            = flag.String("serial-dev", "/dev/serial0", "path to the serial device to read, tcp://host:port of a serial bridge like ser2net or - for stdin, a comma separated list reads several machines with their metrics labeled by device")
+	port                    = flag.Int("port", 8080, "port for the http server to listen on")
+	pushgatewayURL          = flag.String("pushgateway-url", "", "url of a Prometheus Pushgateway to push metrics to, pushing is disabled if empty")
+	pushJob                 = flag.String("push-job", "mara-xporter", "job name to push metrics under to the Pushgateway")
+	pushInterval            = flag.Duration("push-interval", time.Second*15, "interval to push metrics to the Pushgateway in")
+	webhookURL              = flag.String("webhook-url", "", "url to POST JSON events to when the machine becomes ready or overheats, disabled if empty")
+	webhookHxMax            = flag.Uint("webhook-hx-max-temp", 0, "heat exchanger temperature above which an over-temperature event is sent to the webhook, disabled if 0")
+	webhookCool             = flag.Duration("webhook-cooldown", time.Minute*5, "minimum time between two webhook events of the same kind")
+	debug                   = flag.Bool("debug", false, "log additional information like the raw bytes of lines that failed to parse")
+	tempMillidegrees        = flag.Bool("temp-millidegrees", false, "expose temperatures as integer millidegrees celsius, scaling factors are applied before the conversion")
+	modeDebounce            = flag.Int("mode-debounce", 1, "number of consecutive scrapes a new mode has to be read for until the mode change is registered")
+	consolidatedInfo        = flag.Bool("consolidated-info", false, "expose a single mara_x_machine_info metric with the firmware, serial details and alias of the machine instead of mara_x_info")
+	machineAlias            = flag.String("machine-alias", "", "alias of the machine added to the consolidated info metric")
+	ntpServer               = flag.String("ntp-server", "", "NTP server to periodically check the local clock against, disabled if empty")
+	ntpInterval             = flag.Duration("ntp-interval", time.Minute*10, "interval to check the local clock against the NTP server in")
+	ntpMaxOffset            = flag.Duration("ntp-max-offset", time.Second, "offset of the local clock to the NTP server above which a warning is logged")
+	omitZeroCountdown       = flag.Int("omit-zero-countdown-after", 0, "stop exposing the ready countdown once it has been 0 for more than this number of scrapes, this creates gaps in the series. Disabled if 0")
+	nonBlocking             = flag.Bool("serial-nonblocking", false, "open the serial device in non-blocking mode, reads return without data if none is available")
+	allowEmptyVersion       = flag.Bool("allow-empty-version", false, "accept lines without a firmware version and report the version as unknown instead of failing to parse them")
+	goroutineMetric         = flag.Bool("goroutine-metric", false, "expose the number of goroutines of the exporter as mara_x_goroutines to help detecting leaks")
+	demoMode                = flag.Bool("demo", false, "expose synthetic metrics generated in-process instead of reading from the serial device, useful for developing dashboards")
+	structuredNames         = flag.Bool("structured-metric-names", false, "expose metrics with the marax namespace and boiler, serial and exporter subsystems instead of the flat mara_x_ prefix")
+	setTemperature          = flag.Bool("set-temperature", false, "parse an optional seventh field with the set coffee temperature reported by some firmware and expose it as mara_x_set_temperature")
+	logReadings             = flag.Bool("log-readings", false, "log every successfully parsed reading")
+	logReadingsSample       = flag.Int("log-readings-sample", 1, "only log every nth reading if -log-readings is set")
+	heatingDutyWindow       = flag.Duration("heating-duty-window", time.Minute*5, "window to calculate the ratio of time the heating element has been on in")
+	noHTTP                  = flag.Bool("no-http", false, "do not start the HTTP server, for deployments that only push metrics to the Pushgateway or readings polled with -poll-interval to MQTT, InfluxDB, a webhook or a CSV file")
+	userAgent               = flag.String("user-agent", "mara-xporter/"+exporterVersion, "User-Agent header sent with all outbound requests to the Pushgateway and webhook")
+	notHeatingSeconds       = flag.Bool("not-heating-seconds", false, "expose the total number of seconds the heating element has been off")
+	pressureField           = flag.Bool("pressure-field", false, "parse an optional field with the brew pressure in bar reported by machines modded with a pressure sensor, it follows the set temperature if that is enabled too")
+	openMetricsUnits        = flag.Bool("openmetrics-units", false, "declare the units of the temperature metrics to clients accepting OpenMetrics")
+	cpuProfile              = flag.String("cpuprofile", "", "write a CPU profile to this file until the exporter is stopped, disabled if empty")
+	memProfile              = flag.String("memprofile", "", "write a memory profile to this file when the exporter is stopped, disabled if empty")
+	stateMetric             = flag.Bool("state-metric", false, "expose the state of the machine as a mara_x_state stateset of off, heating, ready and error")
+	flowControl             = flag.String("flow-control", flowControlNone, "flow control of the serial port, none or rtscts for hardware flow control")
+	cycleMetrics            = flag.Int("cycle-metrics", 0, "expose the heat-up durations of this many most recent heating cycles labeled by cycle number, disabled if 0")
+	units                   = flag.String("units", unitsCelsius, "unit to expose the temperatures in, celsius or fahrenheit. Fahrenheit metrics are suffixed with _fahrenheit")
+	poweredGrace            = flag.Duration("powered-grace", time.Second*30, "time without a successful read after which the machine is considered powered off")
+	scrapeIntervalMetric    = flag.Bool("scrape-interval-metric", false, "expose the time since the previous scrape to diagnose irregular scraping")
+	pollInterval            = flag.Duration("poll-interval", time.Second, "interval to read the serial port in the background, scrapes are served the latest reading. The serial port is read on every scrape instead if 0")
+	warmupReads             = flag.Int("warmup-reads", 3, "number of lines to read on startup until one parses, garbled lines are discarded and the exporter fails to start if none parses. Not done for stdin and -replay, disabled if 0")
+	warmupRequired          = flag.Bool("warmup-required", true, "fail to start if no line parses during the warm-up, otherwise only log a warning, for example to start while the machine is off")
+	readinessWindow         = flag.Duration("readiness-window", time.Second*10, "time since the last successful read within which /readyz reports the exporter as ready")
+	heatingHistogram        = flag.Bool("heating-histogram", false, "expose a histogram of the durations the heating element was on")
+	shutdownTimeout         = flag.Duration("shutdown-timeout", time.Second*5, "time to wait for open HTTP requests to finish on shutdown")
+	numericMode             = flag.Bool("numeric-mode", false, "decode the mode from a numeric field as sent by some firmware, 0 for coffee and 1 for steam priority, instead of C and V")
+	mqttBroker              = flag.String("mqtt-broker", "", "MQTT broker to publish every reading to, e.g. tcp://localhost:1883. Disabled if empty")
+	mqttTopicPrefix         = flag.String("mqtt-topic-prefix", "maraX", "prefix of the MQTT topics each field of a reading is published to")
+	boilerWatts             = flag.Float64("boiler-watts", 0, "power of the boiler heating element in watts to expose an energy estimate as mara_x_estimated_energy_joules_total. Disabled if 0")
+	livenessTimeout         = flag.Duration("liveness-timeout", time.Minute, "time a single read from the serial port may take before /healthz reports the exporter as wedged")
+	reconnectMinInterval    = flag.Duration("reconnect-min-interval", time.Second*5, "minimum time between two attempts to reopen the serial port, on top of the backoff of failed attempts")
+	replayFile              = flag.String("replay-file", "", "read lines from this file in a loop instead of the serial device, one line per read, to develop dashboards without the machine")
+	twoLineStatus           = flag.Bool("two-line-status", false, "combine status messages split across two lines by some firmware, the mode, version and temperatures on the first and the remaining fields on the second")
+	readTimeout             = flag.Duration("read-timeout", defaultReadTimeout, "time to wait for a line from the serial port before reopening it")
+	onlyChanged             = flag.Bool("only-changed-metrics", false, "omit series from /metrics whose value did not change since the last scrape, for backends preferring sparse writes. This is not supported by Prometheus itself, see the README")
+	countdownMax            = flag.Uint("countdown-max", defaultCountdownMax, "ready countdown the firmware starts fast heating at, used to calculate the fast heating progress")
+	rebootThreshold         = flag.Uint("reboot-threshold", defaultRebootThreshold, "ready countdown at or above which a rising countdown is considered a reboot of the machine, must not exceed -countdown-max")
+	csvFile                 = flag.String("csv-file", "", "append every reading as a row to this CSV file, the rows are buffered and flushed on shutdown. Disabled if empty")
+	logFormat               = flag.String("log-format", logFormatText, "format of the logs, text or json")
+	failedScrapeSelfMetrics = flag.Bool("failed-scrape-self-metrics", true, "still expose the metrics about the exporter itself if reading from the serial port failed, otherwise failed scrapes return no metrics at all")
+	maxStaleness            = flag.Duration("max-staleness", 0, "keep exposing the last successful reading for up to this long if reads fail, marked by mara_x_data_stale. Disabled if 0")
+	serialBaud              = flag.Uint("serial-baud", 9600, "baud rate of the serial device")
+	serialDataBits          = flag.Uint("serial-databits", 8, "number of data bits per character of the serial device, between 5 and 8")
+	serialStopBits          = flag.Uint("serial-stopbits", 1, "number of stop bits of the serial device, 1 or 2")
+	serialMinRead           = flag.Uint("serial-min-read", 4, "minimum number of bytes a read from the serial device waits for, at most 255. Only 0 with -serial-nonblocking")
+	dumpSerial              = flag.Bool("dump", false, "print the raw lines read from the serial device to stdout and exit instead of starting the exporter")
+	dumpCount               = flag.Int("dump-count", 0, "number of lines to print with -dump, unlimited if 0")
+	dumpDuration            = flag.Duration("dump-duration", 0, "time to print lines for with -dump, unlimited if 0")
+	metricPrefix            = flag.String("metric-prefix", defaultMetricPrefix, "prefix of all metric names, also used as the namespace of -structured-metric-names unless left at the default")
+	pprofEndpoints          = flag.Bool("pprof", false, "serve the net/http/pprof profiling endpoints under /debug/pprof/")
+	brewHxDrop              = flag.Uint("brew-hx-drop", 5, "drop of the heat exchanger temperature within -brew-window while heating that is considered a shot being pulled for mara_x_brewing, disabled if 0")
+	brewWindow              = flag.Duration("brew-window", time.Second*15, "window in which the heat exchanger temperature has to drop by -brew-hx-drop to detect brewing")
+	tlsCert                 = flag.String("tls-cert", "", "path to the certificate to serve HTTPS with, requires -tls-key. Plain HTTP is served if empty")
+	tlsKey                  = flag.String("tls-key", "", "path to the private key of -tls-cert")
+	authUser                = flag.String("auth-user", "", "user required with HTTP basic auth to access /metrics, requires -auth-pass. No auth is required if empty")
+	authPass                = flag.String("auth-pass", "", "password of -auth-user")
+	tempAnomalyMargin       = flag.Uint("temp-anomaly-margin", 0, "flag readings in mara_x_temp_anomaly where the steam temperature exceeds its target by more than this many degrees, which hints at misparsed fields. Disabled if 0")
+	influxURL               = flag.String("influx-url", "", "URL of an InfluxDB 2 server to write every reading to in line protocol, requires -influx-bucket. Disabled if empty")
+	influxBucket            = flag.String("influx-bucket", "", "InfluxDB bucket to write the readings to")
+	influxOrg               = flag.String("influx-org", "", "InfluxDB organization of -influx-bucket")
+	influxToken             = flag.String("influx-token", "", "InfluxDB API token to write with")
+	printVersion            = flag.Bool("version", false, "print the version of the exporter and exit")
+	hxTempHistogram         = flag.Bool("hx-temp-histogram", false, "expose a histogram of the hx temperatures read, with the buckets of -hx-temp-buckets")
+	startupGrace            = flag.Duration("startup-grace", 0, "time after startup during which /readyz fails with warming up regardless of the readings, disabled if 0")
+	runOnce                 = flag.Bool("once", false, "read a single status, print the metrics to stdout and exit instead of starting the exporter")
+	httpReadTimeout         = flag.Duration("http-read-timeout", time.Second*10, "maximum duration for reading an HTTP request, unlimited if 0")
+	httpWriteTimeout        = flag.Duration("http-write-timeout", time.Minute, "maximum duration for writing an HTTP response, unlimited if 0. It has to cover the slowest scrape and a CPU profile with -pprof. The /events stream is not cut off, each event has this long to be written instead")
+	httpIdleTimeout         = flag.Duration("http-idle-timeout", time.Minute*2, "maximum time to keep an idle HTTP connection open, unlimited if 0")
+	readRetries             = flag.Int("read-retries", defaultReadRetries, "number of times a failed read is retried within a single scrape before it counts as failed. Each attempt may take twice -read-timeout, as the serial port is reopened and read again after a timeout")
+	minReadInterval         = flag.Duration("min-read-interval", 0, "minimum time between two reads from the serial port, scrapes within it serve the result of the last read. Requires -poll-interval 0, disabled if 0")
+	skipOverlapping         = flag.Bool("skip-overlapping-scrapes", false, "serve the latest reading to a scrape while another one is still reading from the serial port instead of waiting for it, counted by mara_x_scrape_skipped_total. Requires -poll-interval 0")
+	smoothingAlpha          = flag.Float64("smoothing-alpha", 0, "expose exponential moving averages of the steam and hx temperatures as *_smoothed_celsius with this weight of the latest reading between 0 and 1, disabled if 0")
+	legacyMetricNames       = flag.Bool("legacy-metric-names", false, "also expose the temperature metrics under their former names without the _celsius suffix while migrating dashboards and alerts")
+	steamTempWarn           = flag.Uint("steam-temp-warn", 0, "steam temperature in degrees celsius from which mara_x_temp_alert_level is 1, disabled if 0")
+	steamTempCrit           = flag.Uint("steam-temp-crit", 0, "steam temperature in degrees celsius from which mara_x_temp_alert_level is 2, disabled if 0")
+	errReadTimeout          = errors.New("timeout reading from serial device")
+	scales                  = scaleFlag{}
+	constLabels             = labelFlag{}
+	hxTempBuckets           = &bucketsFlag{60, 70, 80, 85, 88, 90, 92, 94, 96, 98, 100, 105, 110}
+	readLatencyObjectives   = objectivesFlag{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+	expectHxRange           = &rangeFlag{}
+	steamTempRange          = &rangeFlag{min: 1, max: 180, set: true}
+	hxTempRange             = &rangeFlag{min: 1, max: 180, set: true}
+	lineFormat              = &lineFormatFlag{"mode_version", "steam_temp", "steam_target_temp", "hx_temp", "ready_countdown", "heating"}
+)
+
+func init() {
+	flag.Var(expectHxRange, "expect-hx-range", "min-max range the heat exchanger temperature of the first reading is expected in, "+
+		"a warning is logged if it is outside. Disabled if empty")
+	flag.Var(steamTempRange, "steam-temp-range", "min-max range of plausible steam temperatures, readings outside of it are dropped as sensor faults. Disabled if empty")
+	flag.Var(hxTempRange, "hx-temp-range", "min-max range of plausible heat exchanger temperatures, readings outside of it are dropped as sensor faults. Disabled if empty")
+	flag.Var(hxTempBuckets, "hx-temp-buckets", "comma separated upper bounds in degrees celsius of the buckets of -hx-temp-histogram")
+	flag.Var(readLatencyObjectives, "read-latency-objectives", "comma separated quantile:error pairs of the quantiles of the serial read latency summary")
+	flag.Var(lineFormat, "line-format", "comma separated order of the fields in a line for firmware sending them differently, "+
+		"_ skips a field. The optional fields follow after them")
+	flag.Var(constLabels, "label", "name=value of a constant label added to all metrics, for example the location of the machine. Can be repeated")
+	flag.Var(scales, "scale", "field=factor to multiply a value with before exposing it, can be repeated. "+
+		"Fields are steam_temp, steam_target_temp, hx_temp, set_temp and ready_countdown")
+}
+
+// parseError is returned if a line was read but could not be parsed.
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// readErrorReason returns the reason label of the read errors metric for err.
+func readErrorReason(err error) string {
+	var parseErr *parseError
+	switch {
+	case errors.As(err, &parseErr):
+		return readErrorParse
+	case errors.Is(err, errReadTimeout):
+		return readErrorTimeout
+	default:
+		return readErrorIO
+	}
+}
+
+// opener opens the serial port with the given options.
+type opener func(options serial.OpenOptions) (io.ReadWriteCloser, error)
+
+// Sink receives every status successfully read from the machine, for example
+// to forward it to a push based backend. Emit is called while scraping and
+// must not block.
+type Sink interface {
+	Emit(status *maraXStatus)
+}
+
+// serialSettings are the settings of the serial port configurable by flags.
+type serialSettings struct {
+	device      string
+	baud        uint
+	dataBits    uint
+	stopBits    uint
+	minRead     uint
+	rtscts      bool
+	nonBlocking bool
+}
+
+// openOptions returns the options to open the serial port with.
+func (settings serialSettings) openOptions() serial.OpenOptions {
+	options := serial.OpenOptions{
+		PortName:          settings.device,
+		BaudRate:          settings.baud,
+		DataBits:          settings.dataBits,
+		StopBits:          settings.stopBits,
+		MinimumReadSize:   settings.minRead,
+		RTSCTSFlowControl: settings.rtscts,
+	}
+	if settings.nonBlocking {
+		// the serial library requires an inter character timeout of at
+		// least 100ms if the minimum read size is 0.
+		options.MinimumReadSize = 0
+		options.InterCharacterTimeout = 100
+	}
+	return options
+}
+
+// serialDevices returns the serial devices of the comma separated list in
+// -serial-dev.
+func serialDevices() []string {
+	var devices []string
+	for _, device := range strings.Split(*serialDevice, ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// serialSettingsFromFlags returns the settings of the serial port at device
+// set by flags.
+func serialSettingsFromFlags(device string) serialSettings {
+	return serialSettings{
+		device:      device,
+		baud:        *serialBaud,
+		dataBits:    *serialDataBits,
+		stopBits:    *serialStopBits,
+		minRead:     *serialMinRead,
+		rtscts:      *flowControl == flowControlRTSCTS,
+		nonBlocking: *nonBlocking,
+	}
+}
+
+// dumpSerialDevice prints the raw lines read from the serial port at device
+// opened with open to stdout for -dump.
+func dumpSerialDevice(open opener, device string) error {
+	port, err := deviceOpener(open, device)(serialSettingsFromFlags(device).openOptions())
+	if err != nil {
+		return &deviceError{device: device, err: err}
+	}
+	defer port.Close()
+	return dump(os.Stdout, port, *dumpCount, *dumpDuration, *readTimeout, *nonBlocking)
+}
+
+// newMaraXCollectors returns a collector for each of the serial devices,
+// opened with open. Devices failing to open are logged and left out so they
+// do not prevent the others from being read, it only fails if none opens.
+func newMaraXCollectors(open opener, devices []string) ([]*maraXCollector, error) {
+	var collectors []*maraXCollector
+	var err error
+	for _, device := range devices {
+		var collector *maraXCollector
+		collector, err = newMaraXCollector(open, device)
+		if err != nil {
+			slog.Error(err.Error(), "device", device)
+			continue
+		}
+		collectors = append(collectors, collector)
+	}
+	if len(collectors) == 0 {
+		return nil, err
+	}
+	return collectors, nil
+}
+
+// newMaraXCollector returns a collector reading from the serial port at
+// device opened with open.
+func newMaraXCollector(open opener, device string) (*maraXCollector, error) {
+	options := serialSettingsFromFlags(device).openOptions()
+	open = deviceOpener(open, device)
+
+	var port io.ReadWriteCloser
+	var err error
+	switch {
+	case *demoMode:
+	case *replayFile != "":
+		open = replayOpener(*replayFile)
+		port, err = open(options)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		port, err = open(options)
+		if err != nil {
+			return nil, &deviceError{device: device, err: err}
+		}
+	}
+
+	collector := newCollector(port, options)
+	collector.open = open
+	// a replay and lines piped to stdin start at the beginning of a line
+	collector.resyncOnOpen = !*demoMode && *replayFile == "" && device != stdinDevice
+	collector.resync = collector.resyncOnOpen
+	collector.readTimeout = *readTimeout
+	collector.readRetries = *readRetries
+	collector.twoLineStatus = *twoLineStatus
+	collector.demo = *demoMode
+	collector.nonBlocking = *nonBlocking
+	collector.scales = scales
+	collector.debug = *debug
+	collector.expectHxRange = *expectHxRange
+	collector.sensorRanges = map[string]rangeFlag{"steam_temp": *steamTempRange, "hx_temp": *hxTempRange}
+	if *logReadings {
+		collector.logReadingsSample = *logReadingsSample
+	}
+	collector.exposeGoroutines = *goroutineMetric
+	collector.exposeState = *stateMetric
+	collector.heatingHistogram = *heatingHistogram
+	collector.hxTempHistogram = *hxTempHistogram
+	collector.exposeScrapeInterval = *scrapeIntervalMetric
+	collector.trackNotHeating = *notHeatingSeconds
+	collector.boilerWatts = *boilerWatts
+	collector.reconnectMinInterval = *reconnectMinInterval
+	collector.modeDebounce = *modeDebounce
+	collector.omitZeroCountdown = *omitZeroCountdown
+	collector.keepCycles = *cycleMetrics
+	collector.poweredGrace = *poweredGrace
+	collector.failedSelfMetrics = *failedScrapeSelfMetrics
+	collector.maxStaleness = *maxStaleness
+	collector.startupGrace = *startupGrace
+	collector.events.writeTimeout = *httpWriteTimeout
+	collector.minReadInterval = *minReadInterval
+	if *smoothingAlpha > 0 {
+		collector.smoothing = true
+		collector.smoothSteam.alpha = *smoothingAlpha
+		collector.smoothHx.alpha = *smoothingAlpha
+	}
+	collector.skipOverlapping = *skipOverlapping
+	collector.tempAnomalyMargin = uint16(*tempAnomalyMargin)
+	collector.steamTempWarn = uint16(*steamTempWarn)
+	collector.steamTempCrit = uint16(*steamTempCrit)
+	collector.countdownMax = uint16(*countdownMax)
+	collector.rebootThreshold = uint16(*rebootThreshold)
+	// only a freshly opened serial port needs a warm-up
+	if collector.resyncOnOpen && *warmupReads > 0 {
+		status, err := collector.warmUp(context.Background(), *warmupReads, warmupTimeout)
+		switch {
+		case err != nil && *warmupRequired:
+			return nil, err
+		case err != nil:
+			slog.Warn("warm-up failed", "err", err)
+		default:
+			collector.mu.Lock()
+			collector.update(status, nil)
+			collector.mu.Unlock()
+		}
+	}
+	return collector, nil
+}
+
+func newCollector(port io.ReadWriteCloser, options serial.OpenOptions) *maraXCollector {
+	events := newBroadcaster()
+	collector := &maraXCollector{
+		serialPort:        port,
+		serialOpts:        options,
+		open:              serial.Open,
+		sinks:             []Sink{events},
+		events:            events,
+		readTimeout:       defaultReadTimeout,
+		readRetries:       defaultReadRetries,
+		modeDebounce:      1,
+		failedSelfMetrics: true,
+		countdownMax:      defaultCountdownMax,
+		rebootThreshold:   defaultRebootThreshold,
+		scales:            scaleFlag{},
+		millidegrees:      *tempMillidegrees,
+		fahrenheit:        *units == unitsFahrenheit,
+		consolidated:      *consolidatedInfo,
+		alias:             *machineAlias,
+		now:               time.Now,
+		started:           time.Now(),
+		hxTempSums:        make(map[mode]float64),
+		hxTempCounts:      make(map[mode]uint64),
+		heatingDuty:       dutyWindow{window: *heatingDutyWindow},
+		brew:              brewDetector{window: *brewWindow, drop: uint16(*brewHxDrop)},
+		info:              infoDesc(),
+		steamTemp: newTemperatureMetric(
+			"boiler", "steam_temperature",
+			"The current steam temperature.",
+		),
+		steamTargetTemp: newTemperatureMetric(
+			"boiler", "steam_target_temperature",
+			"The steam target temperature it wants to reach.",
+		),
+		hxTemp: newTemperatureMetric(
+			"boiler", "hx_temperature",
+			"Temperature of the heat exchanger.",
+		),
+		steamSmoothed: newTemperatureMetric(
+			"boiler", "steam_temperature_smoothed",
+			"Exponential moving average of the steam temperature.",
+		),
+		hxSmoothed: newTemperatureMetric(
+			"boiler", "hx_temperature_smoothed",
+			"Exponential moving average of the temperature of the heat exchanger.",
+		),
+		setTemp: newTemperatureMetric(
+			"boiler", "set_temperature",
+			"The set coffee temperature, only reported by some firmware.",
+		),
+		brewPressure: prometheus.NewDesc(
+			metricName("brew", "brew_pressure_bar"),
+			"Brew pressure in bar, only reported by machines modded with a pressure sensor.",
+			nil, nil,
+		),
+		readyCountdown: prometheus.NewDesc(
+			metricName("boiler", "ready_countdown"),
+			"Shows if the machine is in 'fast heating' mode.",
+			nil, nil,
+		),
+		dataStale: prometheus.NewDesc(
+			metricName("", "data_stale"),
+			"Whether the exposed reading is a previous one as the last read from the serial port failed.",
+			nil, nil,
+		),
+		modeGauge: prometheus.NewDesc(
+			metricName("", "mode"),
+			"Mode of the machine like the mode label of the info metric, 0 for coffee and 1 for steam priority.",
+			nil, nil,
+		),
+		secondsSinceReady: prometheus.NewDesc(
+			metricName("boiler", "seconds_since_ready"),
+			"Seconds since the last reading in which the machine was ready.",
+			nil, nil,
+		),
+		ready: prometheus.NewDesc(
+			metricName("boiler", "ready"),
+			"Whether the machine is ready to pull a shot, derived from the ready countdown being 0.",
+			nil, nil,
+		),
+		readyCountdownInitial: prometheus.NewDesc(
+			metricName("boiler", "ready_countdown_initial"),
+			"The highest ready countdown seen since the start of the current or last heating cycle.",
+			nil, nil,
+		),
+		heatUpDuration: prometheus.NewDesc(
+			metricName("boiler", "heat_up_duration_seconds"),
+			"Time it took the machine to get ready in a heating cycle, numbered since startup.",
+			[]string{"cycle"}, nil,
+		),
+		heating: prometheus.NewDesc(
+			metricName("boiler", "heating"),
+			"Indicates whether the heating element is on or off.",
+			nil, nil,
+		),
+		hxUnchangedReads: prometheus.NewDesc(
+			metricName("boiler", "hx_temperature_unchanged_scrapes"),
+			"Number of consecutive readings where the heat exchanger temperature did not change. While polling with -poll-interval these are the polls rather than the scrapes the name refers to.",
+			nil, nil,
+		),
+		readSuccessRatio: prometheus.NewDesc(
+			metricName("serial", "serial_read_success_ratio"),
+			"Ratio of successful reads from the serial port since startup.",
+			nil, nil,
+		),
+		readySeconds: prometheus.NewDesc(
+			metricName("boiler", "ready_seconds_total"),
+			"Total number of seconds the machine has been ready.",
+			nil, nil,
+		),
+		tempAnomaly: prometheus.NewDesc(
+			metricName("boiler", "temp_anomaly"),
+			"Whether the steam temperature exceeds its target by more than the configured margin, which hints at misparsed fields.",
+			nil, nil,
+		),
+		tempAlertLevel: prometheus.NewDesc(
+			metricName("", "temp_alert_level"),
+			"Alert level of the steam temperature, 0 if it is ok, 1 if it reached the warning and 2 the critical threshold.",
+			nil, nil,
+		),
+		heatingOnSeconds: prometheus.NewDesc(
+			metricName("boiler", "heating_on_seconds_total"),
+			"Total number of seconds the heating element has been on.",
+			nil, nil,
+		),
+		notHeatingSeconds: prometheus.NewDesc(
+			metricName("boiler", "not_heating_seconds_total"),
+			"Total number of seconds the heating element has been off.",
+			nil, nil,
+		),
+		configuredReadTimeout: prometheus.NewDesc(
+			metricName("serial", "configured_read_timeout_seconds"),
+			"The configured timeout for reading a line from the serial port.",
+			nil, nil,
+		),
+		configPollInterval: prometheus.NewDesc(
+			metricName("", "config_poll_interval_seconds"),
+			"The configured interval of reading from the serial port in the background, 0 if it is read on every scrape.",
+			nil, nil,
+		),
+		configReadRetries: prometheus.NewDesc(
+			metricName("", "config_read_retries"),
+			"The configured number of retries of a failed read within a scrape.",
+			nil, nil,
+		),
+		configMaxStaleness: prometheus.NewDesc(
+			metricName("", "config_max_staleness_seconds"),
+			"The configured age up to which the previous reading is served while reads fail, 0 if it is not served.",
+			nil, nil,
+		),
+		hxTempModeAvg: prometheus.NewDesc(
+			metricName("boiler", "hx_temperature_mode_avg"),
+			"Average temperature of the heat exchanger per mode since startup or the last reset.",
+			[]string{"mode"}, nil,
+		),
+		goroutines: prometheus.NewDesc(
+			metricName("exporter", "goroutines"),
+			"Number of goroutines of the exporter.",
+			nil, nil,
+		),
+		lastReadTimestamp: prometheus.NewDesc(
+			metricName("serial", "last_read_timestamp_seconds"),
+			"Unix time of the last line successfully parsed from the serial port.",
+			nil, nil,
+		),
+		lineTimestamp: prometheus.NewDesc(
+			metricName("serial", "line_timestamp_seconds"),
+			"Unix time the last line read was timestamped with by the firmware.",
+			nil, nil,
+		),
+		demoInfo: prometheus.NewDesc(
+			metricName("exporter", "demo_info"),
+			"Set if the exporter is in demo mode, all metrics are synthetic and not from a real machine.",
+			nil, nil,
+		),
+		buildInfo: prometheus.NewDesc(
+			metricName("exporter", "build_info"),
+			"A metric with a constant '1' value labeled by the version, revision and build date of the exporter and the Go version it was built with.",
+			[]string{"version", "revision", "build_date", "goversion"}, nil,
+		),
+		readySecondsEstimate: prometheus.NewDesc(
+			metricName("boiler", "ready_seconds_estimate"),
+			"Estimated seconds until the machine is ready, from the rate the ready countdown decreased at in the current heating cycle.",
+			nil, nil,
+		),
+		estimatedEnergy: prometheus.NewDesc(
+			metricName("boiler", "estimated_energy_joules_total"),
+			"Estimated energy used by the heating element, the configured boiler power times the seconds it has been on.",
+			nil, nil,
+		),
+		heatingDutyRatio: prometheus.NewDesc(
+			metricName("boiler", "heating_duty_ratio"),
+			"Ratio of time the heating element has been on within the configured window.",
+			nil, nil,
+		),
+		brewing: prometheus.NewDesc(
+			metricName("brew", "brewing"),
+			"Whether a shot is likely being pulled, guessed from the hx temperature dropping while heating.",
+			nil, nil,
+		),
+		startupCheckPassed: prometheus.NewDesc(
+			metricName("", "startup_check_passed"),
+			"Whether the first reading after startup was within the expected ranges.",
+			nil, nil,
+		),
+		baudMismatch: prometheus.NewDesc(
+			metricName("serial", "baud_mismatch_suspected"),
+			"Whether the lines read from the serial port hint at a wrong baud rate.",
+			nil, nil,
+		),
+		duplicateLines: prometheus.NewDesc(
+			metricName("serial", "duplicate_lines_total"),
+			"Total number of lines read from the serial port which were identical to the line before.",
+			nil, nil,
+		),
+		state: prometheus.NewDesc(
+			metricName("", "state"),
+			"State of the machine, one of off, heating, ready or error if it could not be read.",
+			[]string{"state"}, nil,
+		),
+		powered: prometheus.NewDesc(
+			metricName("", "machine_powered"),
+			"Whether the machine is considered powered on, it is only considered off once nothing could be read for the configured grace period.",
+			nil, nil,
+		),
+		scrapeInterval: prometheus.NewDesc(
+			metricName("exporter", "scrape_interval_observed_seconds"),
+			"Time since the previous scrape of the exporter.",
+			nil, nil,
+		),
+		unexpectedVersion: prometheus.NewDesc(
+			metricName("", "unexpected_version_change_total"),
+			"Total number of firmware version changes without a reboot of the machine, which hints at cross-talk or a wrong device.",
+			nil, nil,
+		),
+		reboots: prometheus.NewDesc(
+			metricName("", "reboots_total"),
+			"Total number of reboots of the machine, detected by the ready countdown restarting.",
+			nil, nil,
+		),
+		modeChanges: prometheus.NewDesc(
+			metricName("", "mode_changes_total"),
+			"Total number of changes of the mode, after debouncing it with -mode-debounce.",
+			nil, nil,
+		),
+		heatingToggles: prometheus.NewDesc(
+			metricName("boiler", "heating_toggles_total"),
+			"Total number of times the heating element switched on or off between consecutive readings.",
+			nil, nil,
+		),
+		fastHeatingProgress: prometheus.NewDesc(
+			metricName("boiler", "fast_heating_progress_ratio"),
+			"Progress of fast heating derived from the ready countdown, 1 once the machine is ready.",
+			nil, nil,
+		),
+		up: prometheus.NewDesc(
+			metricName("", "up"),
+			"Whether the last read from the serial port succeeded.",
+			nil, nil,
+		),
+		scrapesTotal: prometheus.NewDesc(
+			metricName("exporter", "scrapes_total"),
+			"Total number of scrapes of the exporter.",
+			nil, nil,
+		),
+		scrapesSkipped: prometheus.NewDesc(
+			metricName("exporter", "scrape_skipped_total"),
+			"Total number of scrapes served the latest reading as another scrape was still reading from the serial port.",
+			nil, nil,
+		),
+		serialConnected: prometheus.NewDesc(
+			metricName("serial", "serial_connected"),
+			"Whether the serial device is connected, it is considered disconnected after repeated read errors until it could be reopened.",
+			nil, nil,
+		),
+		sensorFault: prometheus.NewDesc(
+			metricName("", "sensor_fault"),
+			"Whether the field of the last reading was outside of its plausible range, the reading is dropped then.",
+			[]string{"field"}, nil,
+		),
+		parseRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricName("serial", "parse_retries_total"),
+			Help: "Total number of retries needed to read and parse a line from the serial port.",
+		}),
+		reads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricName("serial", "reads_total"),
+			Help: "Total number of statuses successfully read from the serial port.",
+		}),
+		parseDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricName("serial", "parse_duration_seconds"),
+			Help:    "Time spent parsing a line read from the serial port.",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 8),
+		}),
+		readDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricName("serial", "serial_read_duration_seconds"),
+			Help:    "Time spent reading a line from the serial port, including reads which timed out.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 10),
+		}),
+		readLatency: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:       metricName("serial", "serial_read_latency_seconds"),
+			Help:       "Quantiles of the time spent reading a line from the serial port, including reads which timed out.",
+			Objectives: readLatencyObjectives,
+		}),
+		hxTemps: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricName("boiler", "hx_temperature_distribution_celsius"),
+			Help:    "Distribution of the hx temperatures read, in degrees celsius.",
+			Buckets: *hxTempBuckets,
+		}),
+		heatingOn: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricName("boiler", "heating_on_duration_seconds"),
+			Help:    "Duration of the periods the heating element was on.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		readErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricName("serial", "read_errors_total"),
+			Help: "Total number of failed reads of a status from the serial port by reason, one of timeout, parse or io.",
+		}, []string{"reason"}),
+	}
+	// expose all reasons from the start so increases can be alerted on
+	for _, reason := range []string{readErrorTimeout, readErrorParse, readErrorIO} {
+		collector.readErrors.WithLabelValues(reason)
+	}
+	return collector
+}
+
+// infoDesc returns the descriptor of the info metric, which carries the serial
+// details and alias of the machine too if consolidated.
+func infoDesc() *prometheus.Desc {
+	if *consolidatedInfo {
+		return prometheus.NewDesc(
+			metricName("", "machine_info"),
+			"Contains information about the Mara X machine and how it is connected.",
+			[]string{"version", "mode", "serial_device", "baud", "alias"}, nil,
+		)
+	}
+	return prometheus.NewDesc(
+		metricName("", "info"),
+		"Contains information about the Mara X machine.",
+		[]string{"version", "mode"}, nil,
+	)
+}
+
+// boolToFloat returns 1 for true and 0 for false.
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// temperatureMetric is a temperature metric, legacy is its former name
+// without the unit suffix if -legacy-metric-names is set.
+type temperatureMetric struct {
+	desc   *prometheus.Desc
+	legacy *prometheus.Desc
+}
+
+// newTemperatureMetric returns the temperature metric with the given name,
+// which is suffixed with its unit.
+func newTemperatureMetric(subsystem, name, help string) temperatureMetric {
+	if *tempMillidegrees {
+		return temperatureMetric{desc: prometheus.NewDesc(metricName(subsystem, name+"_millicelsius"), help+" In millidegrees celsius.", nil, nil)}
+	}
+	if *units == unitsFahrenheit {
+		return temperatureMetric{desc: prometheus.NewDesc(metricName(subsystem, name+"_fahrenheit"), help+" In degrees fahrenheit.", nil, nil)}
+	}
+	metric := temperatureMetric{desc: prometheus.NewDesc(metricName(subsystem, name+"_celsius"), help, nil, nil)}
+	if *legacyMetricNames {
+		metric.legacy = prometheus.NewDesc(metricName(subsystem, name), help+" Deprecated, use "+metricName(subsystem, name+"_celsius")+".", nil, nil)
+	}
+	return metric
+}
+
+func (m temperatureMetric) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+	if m.legacy != nil {
+		ch <- m.legacy
+	}
+}
+
+func (m temperatureMetric) collect(ch chan<- prometheus.Metric, value float64) {
+	ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, value)
+	if m.legacy != nil {
+		ch <- prometheus.MustNewConstMetric(m.legacy, prometheus.GaugeValue, value)
+	}
+}
+
+// metricName returns the fully qualified name of a metric. By default all
+// metrics are flat with the -metric-prefix, with structured names they are
+// in the marax namespace, or the -metric-prefix if it was changed, and the
+// given subsystem, leaving out the subsystem from the name if it already
+// starts with it.
+func metricName(subsystem, name string) string {
+	if !*structuredNames {
+		return *metricPrefix + "_" + name
+	}
+	namespace := *metricPrefix
+	if namespace == defaultMetricPrefix {
+		namespace = structuredNamespace
+	}
+	return prometheus.BuildFQName(namespace, subsystem, strings.TrimPrefix(name, subsystem+"_"))
+}
+
+func (collector *maraXCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.info
+	ch <- collector.modeGauge
+	ch <- collector.dataStale
+	collector.steamTemp.describe(ch)
+	collector.steamTargetTemp.describe(ch)
+	collector.hxTemp.describe(ch)
+	collector.steamSmoothed.describe(ch)
+	collector.hxSmoothed.describe(ch)
+	ch <- collector.readyCountdown
+	ch <- collector.ready
+	ch <- collector.heating
+	ch <- collector.hxUnchangedReads
+	ch <- collector.readSuccessRatio
+	ch <- collector.readySeconds
+	ch <- collector.secondsSinceReady
+	ch <- collector.readyCountdownInitial
+	ch <- collector.heatUpDuration
+	ch <- collector.notHeatingSeconds
+	ch <- collector.heatingOnSeconds
+	ch <- collector.tempAnomaly
+	ch <- collector.tempAlertLevel
+	ch <- collector.configuredReadTimeout
+	ch <- collector.configPollInterval
+	ch <- collector.configReadRetries
+	ch <- collector.configMaxStaleness
+	ch <- collector.hxTempModeAvg
+	ch <- collector.goroutines
+	ch <- collector.lineTimestamp
+	ch <- collector.lastReadTimestamp
+	ch <- collector.demoInfo
+	collector.setTemp.describe(ch)
+	ch <- collector.brewPressure
+	ch <- collector.heatingDutyRatio
+	ch <- collector.brewing
+	ch <- collector.estimatedEnergy
+	ch <- collector.readySecondsEstimate
+	ch <- collector.buildInfo
+	ch <- collector.startupCheckPassed
+	ch <- collector.baudMismatch
+	ch <- collector.duplicateLines
+	ch <- collector.state
+	ch <- collector.powered
+	ch <- collector.scrapeInterval
+	ch <- collector.unexpectedVersion
+	ch <- collector.reboots
+	ch <- collector.modeChanges
+	ch <- collector.heatingToggles
+	ch <- collector.fastHeatingProgress
+	ch <- collector.serialConnected
+	ch <- collector.up
+	ch <- collector.scrapesTotal
+	ch <- collector.scrapesSkipped
+	ch <- collector.sensorFault
+	collector.parseRetries.Describe(ch)
+	collector.reads.Describe(ch)
+	collector.readErrors.Describe(ch)
+	collector.heatingOn.Describe(ch)
+	collector.hxTemps.Describe(ch)
+	collector.parseDuration.Describe(ch)
+	collector.readDuration.Describe(ch)
+	collector.readLatency.Describe(ch)
+}
+
+func (collector *maraXCollector) Collect(ch chan<- prometheus.Metric) {
+	if collector.demo {
+		collector.collectDemo(ch)
+		return
+	}
+
+	if collector.polling {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		collector.collectLatest(ch)
+		return
+	}
+
+	if collector.minReadInterval > 0 {
+		collector.mu.Lock()
+		recent := !collector.lastReadAttempt.IsZero() && collector.now().Sub(collector.lastReadAttempt) < collector.minReadInterval
+		if recent {
+			collector.collectLatest(ch)
+		}
+		collector.mu.Unlock()
+		if recent {
+			return
+		}
+	}
+
+	var status *maraXStatus
+	var err error
+	if collector.skipOverlapping {
+		if !collector.readMu.TryLock() {
+			collector.mu.Lock()
+			defer collector.mu.Unlock()
+			collector.skippedScrapes++
+			collector.collectLatest(ch)
+			return
+		}
+		status, err = collector.readLocked(context.Background())
+		collector.readMu.Unlock()
+	} else {
+		status, err = collector.read(context.Background())
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.lastReadAttempt = collector.now()
+	collector.update(status, err)
+	collector.collectLatest(ch)
+}
+
+// startPolling reads a status from the serial port in the background on
+// every interval until ctx is done. Collect then serves the latest one
+// instead of reading from the serial port itself. It must be called before
+// the collector is collected for the first time.
+func (collector *maraXCollector) startPolling(ctx context.Context, interval time.Duration) {
+	collector.polling = true
+	collector.pollInterval = interval
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			status, err := collector.read(ctx)
+			// a read aborted by the shutdown is not a failed one
+			if ctx.Err() != nil {
+				return
+			}
+			collector.mu.Lock()
+			collector.update(status, err)
+			collector.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// update tracks the outcome of reading a status, err is set if it failed.
+// The caller must hold collector.mu.
+func (collector *maraXCollector) update(status *maraXStatus, err error) {
+	collector.lastReadFailed = err != nil
+	if err != nil {
+		collector.readFailures++
+		collector.readErrors.WithLabelValues(readErrorReason(err)).Inc()
+		if reason := readErrorReason(err); reason == readErrorParse {
+			slog.Error("unable to parse line from serial port", "err", err)
+		} else {
+			slog.Warn("error reading from serial port", "reason", reason, "err", err)
+		}
+		return
+	}
+
+	collector.readSuccesses++
+	collector.reads.Inc()
+	collector.lastReadTime = collector.now()
+	collector.sensorFaults = validateStatus(status, collector.sensorRanges)
+	faulty := faultyFields(collector.sensorFaults)
+	collector.lastReadFaulty = len(faulty) > 0
+	if collector.lastReadFaulty {
+		slog.Warn("dropping reading with out of range fields", append([]any{"fields", strings.Join(faulty, ",")}, statusAttrs(status)...)...)
+		return
+	}
+	slog.Debug("read status", statusAttrs(status)...)
+
+	collector.track(status)
+	for _, sink := range collector.sinks {
+		sink.Emit(status)
+	}
+}
+
+// collectDemo sends the metrics of a synthetic status.
+func (collector *maraXCollector) collectDemo(ch chan<- prometheus.Metric) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(collector.demoInfo, prometheus.GaugeValue, 1)
+	collector.update(collector.demoStatus(), nil)
+	collector.collectLatest(ch)
+}
+
+// collectLatest sends all metrics of the latest status read. If reading it
+// failed, the previous status is sent as stale data as long as it is not
+// older than maxStaleness. Otherwise only the metrics about the exporter
+// itself are sent, or none at all unless failedSelfMetrics is set. The caller
+// must hold collector.mu.
+func (collector *maraXCollector) collectLatest(ch chan<- prometheus.Metric) {
+	collector.scrapes++
+	stale := collector.lastReadFailed || collector.lastReadFaulty
+	serveStale := stale && collector.previous != nil && collector.maxStaleness > 0 &&
+		collector.now().Sub(collector.previousTime) <= collector.maxStaleness
+	if collector.lastReadFailed && !serveStale && !collector.failedSelfMetrics {
+		return
+	}
+	collector.collectSelfMetrics(ch)
+	for field, faulty := range collector.sensorFaults {
+		ch <- prometheus.MustNewConstMetric(collector.sensorFault, prometheus.GaugeValue, boolToFloat(faulty), field)
+	}
+	if collector.previous == nil || (stale && !serveStale) {
+		collector.collectState(ch, nil)
+		collector.collectPowered(ch)
+		return
+	}
+
+	status := collector.previous
+	collector.collectState(ch, status)
+	collector.collectPowered(ch)
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.info, prometheus.GaugeValue, float64(1), collector.infoLabels(status)...,
+	)
+	ch <- prometheus.MustNewConstMetric(collector.modeGauge, prometheus.GaugeValue, boolToFloat(collector.mode == steam))
+	ch <- prometheus.MustNewConstMetric(collector.dataStale, prometheus.GaugeValue, boolToFloat(stale))
+	collector.steamTemp.collect(ch, collector.temperature("steam_temp", status.steamTemp))
+	collector.steamTargetTemp.collect(ch, collector.temperature("steam_target_temp", status.steamTargetTemp))
+	collector.hxTemp.collect(ch, collector.temperature("hx_temp", status.hxTemp))
+	if collector.smoothing {
+		collector.steamSmoothed.collect(ch, collector.smoothSteam.value)
+		collector.hxSmoothed.collect(ch, collector.smoothHx.value)
+	}
+	if status.setTemp != nil {
+		collector.setTemp.collect(ch, collector.temperature("set_temp", *status.setTemp))
+	}
+	if status.pressure != nil {
+		ch <- prometheus.MustNewConstMetric(collector.brewPressure, prometheus.GaugeValue, *status.pressure)
+	}
+	if collector.omitZeroCountdown == 0 || collector.zeroCountdownScrapes <= collector.omitZeroCountdown {
+		ch <- prometheus.MustNewConstMetric(
+			collector.readyCountdown, prometheus.GaugeValue, collector.scales.scale("ready_countdown", status.readyCountdown),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(collector.ready, prometheus.GaugeValue, boolToFloat(status.readyCountdown == 0))
+	if collector.countdownInitial > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			collector.readyCountdownInitial, prometheus.GaugeValue, collector.scales.scale("ready_countdown", collector.countdownInitial),
+		)
+	}
+	for _, heatUp := range collector.heatUps {
+		ch <- prometheus.MustNewConstMetric(
+			collector.heatUpDuration, prometheus.GaugeValue, heatUp.duration.Seconds(), strconv.FormatUint(heatUp.cycle, 10),
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.heating, prometheus.GaugeValue, boolToFloat(status.heating))
+	if collector.heatingHistogram {
+		collector.heatingOn.Collect(ch)
+	}
+	if collector.hxTempHistogram {
+		collector.hxTemps.Collect(ch)
+	}
+	ch <- prometheus.MustNewConstMetric(collector.hxUnchangedReads, prometheus.GaugeValue, float64(collector.hxUnchanged))
+	ch <- prometheus.MustNewConstMetric(collector.readySeconds, prometheus.CounterValue, collector.readyDuration.Seconds())
+	if !collector.lastReady.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			collector.secondsSinceReady, prometheus.GaugeValue, collector.now().Sub(collector.lastReady).Seconds(),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(collector.unexpectedVersion, prometheus.CounterValue, float64(collector.unexpectedVersionChanges))
+	ch <- prometheus.MustNewConstMetric(collector.reboots, prometheus.CounterValue, float64(collector.rebootCount))
+	ch <- prometheus.MustNewConstMetric(collector.modeChanges, prometheus.CounterValue, float64(collector.modeChangeCount))
+	ch <- prometheus.MustNewConstMetric(collector.heatingToggles, prometheus.CounterValue, float64(collector.heatingToggleCount))
+	ch <- prometheus.MustNewConstMetric(collector.fastHeatingProgress, prometheus.GaugeValue, collector.fastHeatingProgressRatio(status))
+	if collector.tempAnomalyMargin > 0 {
+		ch <- prometheus.MustNewConstMetric(collector.tempAnomaly, prometheus.GaugeValue, boolToFloat(collector.tempAnomalous))
+	}
+	if collector.steamTempWarn > 0 || collector.steamTempCrit > 0 {
+		ch <- prometheus.MustNewConstMetric(collector.tempAlertLevel, prometheus.GaugeValue, collector.tempAlert)
+	}
+	ch <- prometheus.MustNewConstMetric(collector.heatingOnSeconds, prometheus.CounterValue, collector.heatingDuty.on.Seconds())
+	if collector.trackNotHeating {
+		ch <- prometheus.MustNewConstMetric(collector.notHeatingSeconds, prometheus.CounterValue, collector.notHeatingDuration.Seconds())
+	}
+
+	if !status.timestamp.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			collector.lineTimestamp, prometheus.GaugeValue, float64(status.timestamp.UnixNano())/1e9,
+		)
+	}
+
+	if ratio, ok := collector.heatingDuty.ratio(collector.previousTime); ok {
+		ch <- prometheus.MustNewConstMetric(collector.heatingDutyRatio, prometheus.GaugeValue, ratio)
+	}
+	if estimate, ok := collector.estimateReadySeconds(status); ok {
+		ch <- prometheus.MustNewConstMetric(collector.readySecondsEstimate, prometheus.GaugeValue, estimate)
+	}
+	if collector.brew.drop > 0 {
+		ch <- prometheus.MustNewConstMetric(collector.brewing, prometheus.GaugeValue, boolToFloat(collector.brewDetected))
+	}
+	if collector.boilerWatts > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			collector.estimatedEnergy, prometheus.CounterValue, collector.boilerWatts*collector.heatingDuty.on.Seconds(),
+		)
+	}
+
+	if collector.startupCheck != nil {
+		ch <- prometheus.MustNewConstMetric(collector.startupCheckPassed, prometheus.GaugeValue, boolToFloat(*collector.startupCheck))
+	}
+
+	for m, count := range collector.hxTempCounts {
+		ch <- prometheus.MustNewConstMetric(
+			collector.hxTempModeAvg, prometheus.GaugeValue, collector.hxTempSums[m]/float64(count), string(m),
+		)
+	}
+}
+
+// infoLabels returns the label values of the info metric.
+func (collector *maraXCollector) infoLabels(status *maraXStatus) []string {
+	labels := []string{status.version, string(collector.mode)}
+	if collector.consolidated {
+		labels = append(labels,
+			collector.serialOpts.PortName,
+			strconv.FormatUint(uint64(collector.serialOpts.BaudRate), 10),
+			collector.alias,
+		)
+	}
+	return labels
+}
+
+// temperature returns the value of the temperature field to expose.
+func (collector *maraXCollector) temperature(field string, value uint16) float64 {
+	temp := collector.scales.scale(field, value)
+	if collector.fahrenheit {
+		return temp*9/5 + 32
+	}
+	if collector.millidegrees {
+		return math.Round(temp * 1000)
+	}
+	return temp
+}
+
+// collectState exposes the state of the machine derived from the status if
+// enabled. The status is nil if it could not be read.
+func (collector *maraXCollector) collectState(ch chan<- prometheus.Metric, status *maraXStatus) {
+	if !collector.exposeState {
+		return
+	}
+
+	current := machineState(status)
+	for _, state := range machineStates {
+		ch <- prometheus.MustNewConstMetric(collector.state, prometheus.GaugeValue, boolToFloat(state == current), state)
+	}
+}
+
+// collectPowered exposes whether the machine is powered. The machine is
+// considered powered until no status could be read for longer than the
+// grace period since the last one, so brief hiccups in the stream do not
+// flip it.
+func (collector *maraXCollector) collectPowered(ch chan<- prometheus.Metric) {
+	powered := collector.previous != nil && collector.now().Sub(collector.previousTime) <= collector.poweredGrace
+	ch <- prometheus.MustNewConstMetric(collector.powered, prometheus.GaugeValue, boolToFloat(powered))
+}
+
+// collectSelfMetrics sends the metrics about the exporter itself, which are
+// available even if reading from the serial port failed. The caller must hold
+// collector.mu.
+func (collector *maraXCollector) collectSelfMetrics(ch chan<- prometheus.Metric) {
+	// there is no ratio before the first read
+	if reads := collector.readSuccesses + collector.readFailures; reads > 0 {
+		ratio := float64(collector.readSuccesses) / float64(reads)
+		ch <- prometheus.MustNewConstMetric(collector.readSuccessRatio, prometheus.GaugeValue, ratio)
+	}
+	ch <- prometheus.MustNewConstMetric(collector.up, prometheus.GaugeValue, boolToFloat(!collector.lastReadFailed))
+	ch <- prometheus.MustNewConstMetric(
+		collector.buildInfo, prometheus.GaugeValue, 1,
+		exporterVersion, exporterCommit, exporterBuildDate, runtime.Version(),
+	)
+	ch <- prometheus.MustNewConstMetric(collector.scrapesTotal, prometheus.CounterValue, float64(collector.scrapes))
+	ch <- prometheus.MustNewConstMetric(collector.scrapesSkipped, prometheus.CounterValue, float64(collector.skippedScrapes))
+	ch <- prometheus.MustNewConstMetric(collector.configuredReadTimeout, prometheus.GaugeValue, collector.readTimeout.Seconds())
+	ch <- prometheus.MustNewConstMetric(collector.configPollInterval, prometheus.GaugeValue, collector.pollInterval.Seconds())
+	ch <- prometheus.MustNewConstMetric(collector.configReadRetries, prometheus.GaugeValue, float64(collector.readRetries))
+	ch <- prometheus.MustNewConstMetric(collector.configMaxStaleness, prometheus.GaugeValue, collector.maxStaleness.Seconds())
+	ch <- prometheus.MustNewConstMetric(collector.serialConnected, prometheus.GaugeValue, boolToFloat(!collector.disconnected))
+	ch <- prometheus.MustNewConstMetric(collector.baudMismatch, prometheus.GaugeValue, boolToFloat(collector.baud.suspected()))
+	ch <- prometheus.MustNewConstMetric(collector.duplicateLines, prometheus.CounterValue, float64(collector.duplicateLineCount))
+	if !collector.lastReadTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			collector.lastReadTimestamp, prometheus.GaugeValue, float64(collector.lastReadTime.UnixNano())/1e9,
+		)
+	}
+	collector.parseRetries.Collect(ch)
+	collector.reads.Collect(ch)
+	collector.readErrors.Collect(ch)
+	collector.parseDuration.Collect(ch)
+	collector.readDuration.Collect(ch)
+	collector.readLatency.Collect(ch)
+	if collector.exposeGoroutines {
+		ch <- prometheus.MustNewConstMetric(collector.goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()))
+	}
+
+	// there is no interval to observe on the first scrape
+	now := collector.now()
+	if collector.exposeScrapeInterval && !collector.lastScrape.IsZero() {
+		ch <- prometheus.MustNewConstMetric(collector.scrapeInterval, prometheus.GaugeValue, now.Sub(collector.lastScrape).Seconds())
+	}
+	collector.lastScrape = now
+}
+
+// resetHandler resets the per mode averages on POST requests.
+func (collector *maraXCollector) resetHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		collector.mu.Lock()
+		collector.hxTempSums = make(map[mode]float64)
+		collector.hxTempCounts = make(map[mode]uint64)
+		collector.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// healthHandler is the liveness check, it reports the exporter as healthy as
+// long as no read from the serial port has been stuck for longer than the
+// timeout. Unlike readyHandler it does not care whether the reads succeed,
+// a machine which is switched off does not warrant restarting the exporter.
+func (collector *maraXCollector) healthHandler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collector.mu.Lock()
+		started := collector.readStarted
+		wedged := !started.IsZero() && collector.now().Sub(started) > timeout
+		collector.mu.Unlock()
+
+		if wedged {
+			http.Error(w, fmt.Sprintf("read from serial port stuck for more than %s", timeout), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// readyHandler is the readiness check, it reports the exporter as ready once
+// the first reading has been parsed and as long as the last successful read
+// from the serial port happened within the window. During the startup grace
+// it is never ready so probes do not flap while the machine warms up.
+func (collector *maraXCollector) readyHandler(window time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collector.mu.Lock()
+		now := collector.now()
+		warmingUp := collector.startupGrace > 0 && now.Sub(collector.started) < collector.startupGrace
+		ready := collector.previous != nil && now.Sub(collector.previousTime) <= window
+		collector.mu.Unlock()
+
+		if warmingUp {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		if !ready {
+			http.Error(w, fmt.Sprintf("no successful read within %s", window), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// statusHandler serves the last successfully read status as JSON. Until
+// there is one it responds with 503 and the error as JSON.
+func (collector *maraXCollector) statusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collector.mu.Lock()
+		status := collector.previous
+		collector.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if status == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "no successful read from the serial port yet"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(newStatusView(status))
+	})
+}
+
+// track updates the state kept across scrapes with a newly read status. The
+// caller must hold collector.mu.
+func (collector *maraXCollector) track(status *maraXStatus) {
+	now := collector.now()
+
+	if collector.previous != nil && collector.previous.hxTemp == status.hxTemp {
+		collector.hxUnchanged++
+	} else {
+		collector.hxUnchanged = 0
+	}
+
+	// the time since the previous read is attributed to the state the
+	// machine was in at the previous read.
+	if collector.previous != nil && collector.previous.readyCountdown == 0 {
+		collector.readyDuration += now.Sub(collector.previousTime)
+	}
+	if collector.previous != nil && !collector.previous.heating {
+		collector.notHeatingDuration += now.Sub(collector.previousTime)
+	}
+
+	if status.readyCountdown == 0 {
+		collector.lastReady = now
+		collector.zeroCountdownScrapes++
+	} else {
+		collector.zeroCountdownScrapes = 0
+	}
+
+	// a heating cycle starts once the countdown leaves 0
+	cycleStart := status.readyCountdown > 0 && (collector.previous == nil || collector.previous.readyCountdown == 0)
+	if cycleStart || status.readyCountdown > collector.countdownInitial {
+		collector.countdownInitial = status.readyCountdown
+	}
+	if status.readyCountdown == 0 || collector.previous == nil || status.readyCountdown > collector.previous.readyCountdown {
+		collector.countdownFrom = status.readyCountdown
+		collector.countdownSince = now
+	}
+	if cycleStart {
+		collector.cycles++
+		collector.cycleStart = now
+	}
+	if collector.keepCycles > 0 && collector.previous != nil && collector.previous.readyCountdown > 0 && status.readyCountdown == 0 {
+		collector.heatUps = append(collector.heatUps, heatUp{cycle: collector.cycles, duration: now.Sub(collector.cycleStart)})
+		if len(collector.heatUps) > collector.keepCycles {
+			collector.heatUps = collector.heatUps[len(collector.heatUps)-collector.keepCycles:]
+		}
+	}
+
+	if collector.previous == nil {
+		collector.checkStartup(status)
+	}
+	if collector.rebooted(status) {
+		collector.rebootCount++
+	}
+	if collector.previous != nil && collector.previous.heating != status.heating {
+		collector.heatingToggleCount++
+	}
+	collector.checkVersion(status)
+	collector.checkTempAnomaly(status)
+	collector.tempAlert = collector.steamTempAlert(status)
+	collector.logReading(status)
+	collector.heatingDuty.add(now, status.heating)
+	collector.brewDetected = collector.brew.add(now, status.hxTemp, status.heating)
+	wasHeating := collector.previous != nil && collector.previous.heating
+	if status.heating && !wasHeating {
+		collector.heatingSince = now
+	}
+	// a period still in progress is only observed once it ended
+	if !status.heating && wasHeating {
+		collector.heatingOn.Observe(now.Sub(collector.heatingSince).Seconds())
+	}
+	collector.hxTemps.Observe(float64(status.hxTemp))
+	if collector.smoothing {
+		collector.smoothSteam.add(collector.temperature("steam_temp", status.steamTemp))
+		collector.smoothHx.add(collector.temperature("hx_temp", status.hxTemp))
+	}
+	collector.trackMode(status.mode)
+	collector.hxTempSums[collector.mode] += float64(status.hxTemp)
+	collector.hxTempCounts[collector.mode]++
+
+	collector.previous = status
+	collector.previousTime = now
+}
+
+// rebooted returns whether the machine rebooted since the previous status. A
+// reboot restarts the ready countdown, so it is detected by the countdown going
+// up to at least the reboot threshold. The caller must hold collector.mu.
+func (collector *maraXCollector) rebooted(status *maraXStatus) bool {
+	previous := collector.previous
+	return previous != nil && status.readyCountdown > previous.readyCountdown && status.readyCountdown >= collector.rebootThreshold
+}
+
+// estimateReadySeconds returns the estimated seconds until the countdown of
+// status reaches 0 at the rate it has been decreasing at. It returns false if
+// the countdown did not decrease yet.
+func (collector *maraXCollector) estimateReadySeconds(status *maraXStatus) (float64, bool) {
+	if status.readyCountdown == 0 {
+		return 0, true
+	}
+	elapsed := collector.previousTime.Sub(collector.countdownSince).Seconds()
+	if elapsed <= 0 || collector.countdownFrom <= status.readyCountdown {
+		return 0, false
+	}
+	rate := float64(collector.countdownFrom-status.readyCountdown) / elapsed
+	return float64(status.readyCountdown) / rate, true
+}
+
+// fastHeatingProgressRatio returns how far fast heating has progressed from
+// countdownMax down to 0. Countdowns above countdownMax count as no progress.
+func (collector *maraXCollector) fastHeatingProgressRatio(status *maraXStatus) float64 {
+	if status.readyCountdown >= collector.countdownMax {
+		return 0
+	}
+	return 1 - float64(status.readyCountdown)/float64(collector.countdownMax)
+}
+
+// checkVersion warns about a firmware version change which did not come with
+// a reboot of the machine. The caller must hold collector.mu.
+func (collector *maraXCollector) checkVersion(status *maraXStatus) {
+	previous := collector.previous
+	if previous == nil || previous.version == status.version {
+		return
+	}
+
+	if collector.rebooted(status) {
+		slog.Info("firmware version changed after a reboot", "from", previous.version, "to", status.version)
+		return
+	}
+	collector.unexpectedVersionChanges++
+	slog.Warn(
+		"firmware version changed without a reboot, check for cross-talk or a wrong serial device",
+		"from", previous.version, "to", status.version,
+	)
+}
+
+// checkTempAnomaly flags readings with a steam temperature exceeding its
+// target by more than tempAnomalyMargin. The first anomalous reading in a row
+// is logged. The caller must hold collector.mu.
+func (collector *maraXCollector) checkTempAnomaly(status *maraXStatus) {
+	if collector.tempAnomalyMargin == 0 {
+		return
+	}
+
+	anomalous := int(status.steamTemp)-int(status.steamTargetTemp) > int(collector.tempAnomalyMargin)
+	if anomalous && !collector.tempAnomalous {
+		slog.Warn(
+			"steam temperature exceeds its target, check for misparsed fields",
+			"steam_temp", status.steamTemp, "steam_target_temp", status.steamTargetTemp,
+		)
+	}
+	collector.tempAnomalous = anomalous
+}
+
+// steamTempAlert returns the alert level of the steam temperature of status,
+// alertCrit if it reached steamTempCrit and alertWarn if it reached
+// steamTempWarn.
+func (collector *maraXCollector) steamTempAlert(status *maraXStatus) float64 {
+	switch {
+	case collector.steamTempCrit > 0 && status.steamTemp >= collector.steamTempCrit:
+		return alertCrit
+	case collector.steamTempWarn > 0 && status.steamTemp >= collector.steamTempWarn:
+		return alertWarn
+	}
+	return alertOK
+}
+
+// checkStartup compares the first reading against the expected ranges to catch
+// swapped sensors or a misconfigured machine early. The caller must hold
+// collector.mu.
+func (collector *maraXCollector) checkStartup(status *maraXStatus) {
+	if !collector.expectHxRange.set {
+		return
+	}
+
+	passed := collector.expectHxRange.contains(status.hxTemp)
+	if !passed {
+		slog.Warn(
+			"heat exchanger temperature of the first reading is outside the expected range",
+			"hx_temp", status.hxTemp, "range", collector.expectHxRange.String(),
+		)
+	}
+	collector.startupCheck = &passed
 }
 
-// maraXStatus is all the data returned by the Mara X serial UART port.
-type maraXStatus struct {
-	// version is the firmware version of the thing.
-	version string
-	// mode is the mode the machine is in. C for coffee priority, V for vapour (steam) priority.
-	mode mode
-	// steamTemp is the current steam temperature
-	steamTemp uint16
-	// steamTargetTemp is the steam target temperature it wants to reach
-	steamTargetTemp uint16
-	// hxTemp is the current temperature of the heat exchanger
-	hxTemp uint16
-	// readyCountdown shows if the machine is in "fast heating" mode. If so, it
-	// will start somewhere at 1500 and eventually end up at 0 once it's done.
-	readyCountdown uint16
-	// heating indicates whether the heating element is on or off.
-	heating bool
+// logReading logs the status if it is sampled. The caller must hold
+// collector.mu.
+func (collector *maraXCollector) logReading(status *maraXStatus) {
+	if collector.logReadingsSample == 0 {
+		return
+	}
+
+	collector.readings++
+	if (collector.readings-1)%uint64(collector.logReadingsSample) != 0 {
+		return
+	}
+	slog.Info("read status", statusAttrs(status)...)
 }
 
-type mode string
+// trackMode registers a change of the mode once it persisted for the
+// configured number of scrapes, so brief flaps of the mode are ignored. The
+// caller must hold collector.mu.
+func (collector *maraXCollector) trackMode(m mode) {
+	if collector.previous == nil || m == collector.mode {
+		collector.mode = m
+		collector.pendingModeScrapes = 0
+		return
+	}
 
-const (
-	coffee mode = "coffee"
-	steam  mode = "steam"
+	if m != collector.pendingMode {
+		collector.pendingMode = m
+		collector.pendingModeScrapes = 0
+	}
+	collector.pendingModeScrapes++
 
-	coffeeMode = "C"
-	steamMode  = "V"
-)
+	if collector.pendingModeScrapes >= collector.modeDebounce {
+		collector.mode = m
+		collector.pendingModeScrapes = 0
+		collector.modeChangeCount++
+	}
+}
 
-var (
-	serialDevice   = flag.String("serial-dev", "/dev/serial0", "path to the serial device to read")
-	port           = flag.Int("port", 8080, "port for the http server to listen on")
-	errReadTimeout = errors.New("timeout reading from serial device")
-)
+func main() {
+	flag.Parse()
+	if *printVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if err := validateFlags(); err != nil {
+		fatal(err)
+	}
+	if err := start(); err != nil {
+		fatal(err)
+	}
+}
 
-func newMaraXCollector() (*maraXCollector, error) {
-	options := serial.OpenOptions{
-		PortName:        *serialDevice,
-		BaudRate:        9600,
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: 4,
+// start runs the exporter with the parsed flags until it is interrupted. The
+// errors it returns are mapped to the exit code by exitCode.
+func start() error {
+	setupLogging(os.Stderr, *logFormat, *debug)
+	stopProfiling, err := startProfiling(*cpuProfile, *memProfile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stopProfiling(); err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+
+	if *dumpSerial {
+		return dumpSerialDevice(serial.Open, serialDevices()[0])
 	}
 
-	port, err := serial.Open(options)
+	collectors, err := newMaraXCollectors(serial.Open, serialDevices())
 	if err != nil {
-		return nil, fmt.Errorf("unable to open serial device at %s: %w", *serialDevice, err)
+		return err
+	}
+	client := newHTTPClient(*userAgent)
+	if *webhookURL != "" {
+		for _, collector := range collectors {
+			collector.sinks = append(collector.sinks, newWebhook(*webhookURL, uint16(*webhookHxMax), *webhookCool, client))
+		}
+	}
+	if *mqttBroker != "" {
+		mqttClient, err := connectMQTT(*mqttBroker)
+		if err != nil {
+			return err
+		}
+		for _, collector := range collectors {
+			prefix := *mqttTopicPrefix
+			if len(collectors) > 1 {
+				prefix += "/" + path.Base(collector.serialOpts.PortName)
+			}
+			collector.sinks = append(collector.sinks, newMQTTPublisher(mqttClient, prefix))
+		}
+	}
+	if *influxURL != "" {
+		writer := newInfluxHTTPWriter(*influxURL, *influxOrg, *influxBucket, *influxToken, client)
+		for _, collector := range collectors {
+			collector.sinks = append(collector.sinks, newInfluxSink(writer))
+		}
+	}
+	// -csv-file is only allowed with a single device
+	if *csvFile != "" {
+		collector := collectors[0]
+		csv, err := openCSVSink(*csvFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := csv.Close(); err != nil {
+				slog.Error(err.Error())
+			}
+		}()
+		collector.sinks = append(collector.sinks, csv)
+	}
+	registerCollectors(prometheus.DefaultRegisterer, collectors)
+	if *runOnce {
+		defer closeCollectors(collectors)
+		return scrapeOnce(os.Stdout, prometheus.DefaultGatherer, collectors)
 	}
 
-	return &maraXCollector{
-		serialPort: port,
-		serialOpts: options,
-		info: prometheus.NewDesc(
-			"mara_x_info",
-			"Contains information about the Mara X machine.",
-			[]string{"version", "mode"}, nil,
-		),
-		steamTemp: prometheus.NewDesc(
-			"mara_x_steam_temperature",
-			"The steam target temperature it wants to reach.",
-			nil, nil,
-		),
-		steamTargetTemp: prometheus.NewDesc(
-			"mara_x_steam_target_temperature",
-			"The current steam temperature.",
-			nil, nil,
-		),
-		hxTemp: prometheus.NewDesc(
-			"mara_x_hx_temperature",
-			"Temperature of the heat exchanger.",
-			nil, nil,
-		),
-		readyCountdown: prometheus.NewDesc(
-			"mara_x_ready_countdown",
-			"Shows if the machine is in 'fast heating' mode.",
-			nil, nil,
-		),
-		heating: prometheus.NewDesc(
-			"mara_x_heating",
-			"Indicates whether the heating element is on or off.",
-			nil, nil,
-		),
-	}, nil
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// a replay advances by one line per scrape, polling would skip lines
+	if *pollInterval > 0 && !*demoMode && *replayFile == "" {
+		for _, collector := range collectors {
+			collector.startPolling(ctx, *pollInterval)
+		}
+	}
+
+	if *ntpServer != "" {
+		checker := newClockChecker(*ntpServer, *ntpMaxOffset)
+		prometheus.MustRegister(checker)
+		go checker.run(ctx, *ntpInterval)
+	}
+
+	run(ctx, collectors, client, listenAndServe(*tlsCert, *tlsKey))
+	return nil
 }
 
-func (collector *maraXCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- collector.steamTemp
+// registerCollectors registers the collectors with registerer, labeling
+// their metrics with the labels of collectorLabels.
+func registerCollectors(registerer prometheus.Registerer, collectors []*maraXCollector) {
+	for _, collector := range collectors {
+		labels := collectorLabels(collector, len(collectors) > 1)
+		if len(labels) == 0 {
+			registerer.MustRegister(collector)
+			continue
+		}
+		prometheus.WrapRegistererWith(labels, registerer).MustRegister(collector)
+	}
 }
 
-func (collector *maraXCollector) Collect(ch chan<- prometheus.Metric) {
-	status, err := collector.collectDataFromSerial()
-	if err != nil {
-		log.Printf("error collecting metrics from serial port: %s", err)
-		return
+// collectorLabels returns the constant labels of -label and, if the collector
+// is one of several, its serial device identifying the machine.
+func collectorLabels(collector *maraXCollector, several bool) prometheus.Labels {
+	labels := make(prometheus.Labels, len(constLabels)+1)
+	for name, value := range constLabels {
+		labels[name] = value
+	}
+	if several {
+		labels["device"] = collector.serialOpts.PortName
 	}
+	return labels
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		collector.info, prometheus.GaugeValue, float64(1), status.version, string(status.mode),
+// newMux returns the handler serving all HTTP endpoints of the exporter. The
+// requests to them are counted by a metric registered with registerer.
+func newMux(collector *maraXCollector, registerer prometheus.Registerer) *http.ServeMux {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricName("exporter", "http_requests_total"),
+		Help: "Total number of HTTP requests to the exporter by path and status code.",
+	}, []string{"path", "code"})
+	registerer.MustRegister(requests)
+
+	mux := http.NewServeMux()
+	instrument := func(path string, handler http.Handler) http.Handler {
+		return promhttp.InstrumentHandlerCounter(requests.MustCurryWith(prometheus.Labels{"path": path}), handler)
+	}
+	handle := func(path string, handler http.Handler) {
+		mux.Handle(path, instrument(path, handler))
+	}
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if *onlyChanged {
+		gatherer = newChangedGatherer(gatherer)
+	}
+	var metrics http.Handler = promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, metricsHandler(gatherer),
 	)
-	ch <- prometheus.MustNewConstMetric(collector.steamTemp, prometheus.GaugeValue, float64(status.steamTemp))
-	ch <- prometheus.MustNewConstMetric(collector.steamTargetTemp, prometheus.GaugeValue, float64(status.steamTargetTemp))
-	ch <- prometheus.MustNewConstMetric(collector.hxTemp, prometheus.GaugeValue, float64(status.hxTemp))
-	ch <- prometheus.MustNewConstMetric(collector.readyCountdown, prometheus.GaugeValue, float64(status.readyCountdown))
+	if *authUser != "" {
+		metrics = basicAuth(*authUser, *authPass, metrics)
+	}
+	handle("/metrics", metrics)
+	handle("/reset", collector.resetHandler())
+	// the events stream moves the write deadline of the connection itself
+	mux.Handle("/events", withResponseController(instrument("/events", collector.events)))
+	handle("/healthz", collector.healthHandler(*livenessTimeout))
+	handle("/readyz", collector.readyHandler(*readinessWindow))
+	handle("/status", collector.statusHandler())
+	if *pprofEndpoints {
+		handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+		handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+		handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+		handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+		handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	}
+	return mux
+}
+
+// run starts the HTTP server with serve unless -no-http is set and pushes to
+// the Pushgateway with client if configured. Once ctx is done, the HTTP
+// server is shut down gracefully and the serial ports are closed. The
+// endpoints other than /metrics serve the first of the collectors.
+func run(ctx context.Context, collectors []*maraXCollector, client *http.Client, serve func(*http.Server) error) {
+	server := newHTTPServer(fmt.Sprintf(":%v", *port), *httpReadTimeout, *httpWriteTimeout, *httpIdleTimeout)
+	if !*noHTTP {
+		server.Handler = newMux(collectors[0], prometheus.DefaultRegisterer)
+		go func() {
+			if err := serve(server); !errors.Is(err, http.ErrServerClosed) {
+				fatal(err)
+			}
+		}()
+	}
+
+	if *pushgatewayURL != "" {
+		var wg sync.WaitGroup
+		for _, collector := range collectors {
+			pusher := newPusher(*pushgatewayURL, *pushJob, collector, client)
+			// the Pushgateway adds the grouping labels to all metrics
+			for name, value := range collectorLabels(collector, len(collectors) > 1) {
+				pusher.Grouping(name, value)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runPusher(ctx, pusher, *pushInterval)
+			}()
+		}
+		wg.Wait()
+	} else {
+		<-ctx.Done()
+	}
 
-	heating := 0
-	if status.heating {
-		heating = 1
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error shutting down http server", "err", err)
 	}
-	ch <- prometheus.MustNewConstMetric(collector.heating, prometheus.GaugeValue, float64(heating))
+	closeCollectors(collectors)
 }
 
-func main() {
-	flag.Parse()
-	collector, err := newMaraXCollector()
+// closeCollectors closes the serial ports of the collectors, logging errors.
+func closeCollectors(collectors []*maraXCollector) {
+	for _, collector := range collectors {
+		if err := collector.close(); err != nil {
+			slog.Error("error closing serial port", "err", err, "device", collector.serialOpts.PortName)
+		}
+	}
+}
+
+// close closes the serial port, if any.
+func (collector *maraXCollector) close() error {
+	if collector.serialPort == nil {
+		return nil
+	}
+	return collector.serialPort.Close()
+}
+
+// read reads a status from the serial port. After reconnectAfterErrors
+// consecutive io errors the serial port is considered disconnected, for
+// example because the adapter was unplugged, and is reopened with an
+// exponential backoff. Timeouts are not counted, they are expected while
+// the machine is off and already reopen the serial port. The read is aborted
+// once ctx is done.
+func (collector *maraXCollector) read(ctx context.Context) (*maraXStatus, error) {
+	collector.readMu.Lock()
+	defer collector.readMu.Unlock()
+	return collector.readLocked(ctx)
+}
+
+// readLocked is read for callers already holding collector.readMu.
+func (collector *maraXCollector) readLocked(ctx context.Context) (*maraXStatus, error) {
+	collector.mu.Lock()
+	collector.readStarted = collector.now()
+	collector.mu.Unlock()
+	defer func() {
+		collector.mu.Lock()
+		collector.readStarted = time.Time{}
+		collector.mu.Unlock()
+	}()
+
+	if !collector.isConnected() {
+		if err := collector.reconnect(); err != nil {
+			return nil, err
+		}
+	}
+
+	status, err := collector.collectDataFromSerial(ctx)
+	if err == nil || readErrorReason(err) != readErrorIO || ctx.Err() != nil {
+		collector.readErrorsInRow = 0
+		return status, err
+	}
+
+	collector.readErrorsInRow++
+	if collector.readErrorsInRow >= reconnectAfterErrors {
+		slog.Warn("serial device disconnected", "device", collector.serialOpts.PortName, "read_errors", collector.readErrorsInRow)
+		_ = collector.serialPort.Close()
+		collector.reader = nil
+		collector.readErrorsInRow = 0
+		collector.reconnectAt = collector.now()
+		collector.reconnectBackoff = minReconnectBackoff
+		collector.setConnected(false)
+	}
+	return nil, err
+}
+
+// reconnect reopens the serial port if the backoff since the last attempt
+// has passed.
+func (collector *maraXCollector) reconnect() error {
+	now := collector.now()
+	next := collector.reconnectAt
+	if earliest := collector.lastReconnect.Add(collector.reconnectMinInterval); !collector.lastReconnect.IsZero() && earliest.After(next) {
+		next = earliest
+	}
+	if now.Before(next) {
+		return fmt.Errorf("serial device at %s is disconnected, reconnecting in %s", collector.serialOpts.PortName, next.Sub(now))
+	}
+
+	collector.lastReconnect = now
+	port, err := collector.open(collector.serialOpts)
 	if err != nil {
-		log.Fatal(err)
+		collector.reconnectAt = now.Add(collector.reconnectBackoff)
+		collector.reconnectBackoff *= 2
+		if collector.reconnectBackoff > maxReconnectBackoff {
+			collector.reconnectBackoff = maxReconnectBackoff
+		}
+		return fmt.Errorf("unable to reconnect serial device at %s: %w", collector.serialOpts.PortName, err)
+	}
+
+	slog.Info("reconnected serial device", "device", collector.serialOpts.PortName)
+	collector.serialPort = port
+	collector.resync = collector.resyncOnOpen
+	collector.setConnected(true)
+	return nil
+}
+
+func (collector *maraXCollector) isConnected() bool {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	return !collector.disconnected
+}
+
+func (collector *maraXCollector) setConnected(connected bool) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.disconnected = !connected
+}
+
+// warmUp discards the partial or garbled lines the serial port often emits
+// right after it was opened. It reads up to reads lines until one parses and
+// returns its status, or an error if none did within the timeout.
+func (collector *maraXCollector) warmUp(ctx context.Context, reads int, timeout time.Duration) (*maraXStatus, error) {
+	deadline := collector.now().Add(timeout)
+	err := errors.New("timed out")
+	for i := 0; i < reads && collector.now().Before(deadline); i++ {
+		var line []byte
+		line, err = collector.readStatusLine(ctx)
+		if err != nil {
+			continue
+		}
+		var status *maraXStatus
+		if status, err = parseLine(line); err == nil {
+			return status, nil
+		}
 	}
-	prometheus.MustRegister(collector)
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(fmt.Sprintf(":%v", *port), nil)
+	return nil, fmt.Errorf("no valid line read from serial device at %s during warm-up: %w", collector.serialOpts.PortName, err)
 }
 
-func (collector *maraXCollector) collectDataFromSerial() (*maraXStatus, error) {
+func (collector *maraXCollector) collectDataFromSerial(ctx context.Context) (*maraXStatus, error) {
 	var err error
 
-	// as reading from serial can be very error-prone, we simply try 3 times
-	// until we return
-	for i := 0; i < 3; i++ {
+	// as reading from serial can be very error-prone, we retry a few times
+	// until we return. The next line is usually only milliseconds away, so
+	// the backoff is short. As a timed out read reopens the serial port and
+	// reads again, each attempt may take twice the read timeout, a scrape
+	// takes up to 2 * readTimeout * (readRetries + 1) plus the backoffs.
+	for i := 0; i <= collector.readRetries; i++ {
+		if i > 0 {
+			collector.parseRetries.Inc()
+		}
+
 		var line []byte
-		line, err = collector.readSerialLine()
+		line, err = collector.readStatusLine(ctx)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			if i == collector.readRetries {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(readRetryBackoff):
+			}
+			continue
+		}
+
+		var status *maraXStatus
+		parseStart := time.Now()
+		status, err = parseLine(line)
+		collector.parseDuration.Observe(time.Since(parseStart).Seconds())
+
+		collector.mu.Lock()
+		collector.baud.observe(line, err == nil)
+		if collector.lastLine != nil && bytes.Equal(collector.lastLine, line) {
+			collector.duplicateLineCount++
+		}
+		// the line may point into the buffer of the reader
+		collector.lastLine = append(collector.lastLine[:0], line...)
+		collector.mu.Unlock()
+
 		if err == nil {
-			return parseLine(line)
+			return status, nil
+		}
+		err = &parseError{err: err}
+		if collector.debug {
+			slog.Debug("unable to parse raw line", "hex", hex.Dump(line))
 		}
 	}
 
 	return nil, err
 }
 
-func (collector *maraXCollector) readSerialLine() ([]byte, error) {
-	data, err := readLine(collector.serialPort, time.Second*1)
+func (collector *maraXCollector) readSerialLine(ctx context.Context) ([]byte, error) {
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start).Seconds()
+		collector.readDuration.Observe(elapsed)
+		collector.readLatency.Observe(elapsed)
+	}()
+
+	data, err := collector.readLine(ctx)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if errors.Is(err, errReadTimeout) {
-		log.Println("reopening serial port")
+		slog.Warn("read from serial port timed out, reopening it", "device", collector.serialOpts.PortName)
 		_ = collector.serialPort.Close()
+		collector.reader = nil
 		// we try to reopen the serial device and read again
-		collector.serialPort, err = serial.Open(collector.serialOpts)
+		collector.serialPort, err = collector.open(collector.serialOpts)
 		if err != nil {
-			return nil, fmt.Errorf("unable to reopen serial device at %s: %w", *serialDevice, err)
+			return nil, fmt.Errorf("unable to reopen serial device at %s: %w", collector.serialOpts.PortName, err)
 		}
-		return readLine(collector.serialPort, time.Second*1)
+		collector.resync = collector.resyncOnOpen
+		return collector.readLine(ctx)
 	}
 
 	if err != nil {
@@ -187,84 +2306,387 @@ func (collector *maraXCollector) readSerialLine() ([]byte, error) {
 	return data, nil
 }
 
+// readStatusLine reads the line of a single status, joining the two lines of
+// it if the firmware splits it.
+func (collector *maraXCollector) readStatusLine(ctx context.Context) ([]byte, error) {
+	if collector.twoLineStatus {
+		return collector.readLinePair(ctx)
+	}
+	return collector.readSerialLine(ctx)
+}
+
+// readLinePair reads a status which is split across two lines and joins them
+// into a single line. The first line of a pair is recognized by its number of
+// fields, a second line read on its own is dropped as the pair started before
+// we began reading.
+func (collector *maraXCollector) readLinePair(ctx context.Context) ([]byte, error) {
+	first, err := collector.readSerialLine(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Count(first, []byte(",")) != firstLineFields-1 {
+		first, err = collector.readSerialLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the line may point into the buffer of the reader
+	pair := append([]byte(nil), bytes.TrimRight(first, "\r\n")...)
+
+	second, err := collector.readSerialLine(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read second line of status: %w", err)
+	}
+	return append(append(pair, ','), second...), nil
+}
+
 func parseLine(l []byte) (*maraXStatus, error) {
+	return parseLineWithFormat(l, *lineFormat)
+}
+
+// parseLineWithFormat parses a line with its fields in the order of format.
+func parseLineWithFormat(l []byte, format lineFormatFlag) (*maraXStatus, error) {
 	line := string(l)
-	line = strings.TrimSuffix(line, "\r\n")
+	// the machine ends lines with CRLF, a bare LF or CR and any combination
+	// of them are accepted too. A control character left within the line is a
+	// glitch of the UART which would otherwise end up in one of the fields.
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.IndexFunc(line, isLineControl); i >= 0 {
+		return nil, fmt.Errorf("unable to parse line %q, it contains the control character %q", line, line[i])
+	}
+	timestamp, line := splitTimestamp(line)
+
+	// the optional fields follow the standard ones in a fixed order, any of
+	// them may be missing from the end of the line.
+	optional := 0
+	for _, enabled := range []bool{*setTemperature, *pressureField} {
+		if enabled {
+			optional++
+		}
+	}
 
+	// a noisy UART may add whitespace around the fields or a trailing comma
 	parts := strings.Split(string(line), ",")
-	if len(parts) != 6 {
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	standard := len(format)
+	if len(parts) > standard && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) < standard || len(parts) > standard+optional {
 		return nil, fmt.Errorf(
 			"unable to parse line %s, it does not contain expected parts", line,
 		)
 	}
+	fields := make(map[string]string, standard)
+	for i, name := range format {
+		fields[name] = parts[i]
+	}
 
-	modeVersion := strings.Split(parts[0], "")
-	if len(modeVersion) < 2 {
+	modeVersion := strings.Split(fields["mode_version"], "")
+	if len(modeVersion) < 1 {
 		return nil, fmt.Errorf(
 			"unable to parse line %s, the mode and version parts could not be found", line,
 		)
 	}
 
-	steamTemp, err := strconv.Atoi(parts[1])
+	version := strings.Join(modeVersion[1:], "")
+	if version == "" {
+		if !*allowEmptyVersion {
+			return nil, fmt.Errorf(
+				"unable to parse line %s, the version is empty", line,
+			)
+		}
+		version = unknownVersion
+	} else if !versionPattern.MatchString(version) {
+		return nil, fmt.Errorf(
+			"unable to parse line %s, unexpected version format %q", line, version,
+		)
+	}
+
+	steamTemp, err := strconv.Atoi(fields["steam_temp"])
 	if err != nil {
 		return nil, err
 	}
 
-	steamTargetTemp, err := strconv.Atoi(parts[2])
+	steamTargetTemp, err := strconv.Atoi(fields["steam_target_temp"])
 	if err != nil {
 		return nil, err
 	}
 
-	hxTemp, err := strconv.Atoi(parts[3])
+	hxTemp, err := strconv.Atoi(fields["hx_temp"])
 	if err != nil {
 		return nil, err
 	}
 
-	readyCountdown, err := strconv.Atoi(parts[4])
+	readyCountdown, err := strconv.Atoi(fields["ready_countdown"])
 	if err != nil {
 		return nil, err
 	}
 
-	heating, err := strconv.ParseBool(parts[5])
+	extra := parts[standard:]
+	var setTemp *uint16
+	if *setTemperature && len(extra) > 0 {
+		temp, err := strconv.Atoi(extra[0])
+		if err != nil {
+			return nil, err
+		}
+		t := uint16(temp)
+		setTemp = &t
+		extra = extra[1:]
+	}
+
+	var pressure *float64
+	if *pressureField && len(extra) > 0 {
+		p, err := strconv.ParseFloat(extra[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		pressure = &p
+	}
+
+	heating, err := strconv.ParseBool(fields["heating"])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse line %s, invalid heating field: %w", line, err)
+	}
 
-	mode := coffee
-	if modeVersion[0] == steamMode {
-		mode = steam
+	mode, err := parseMode(modeVersion[0], *numericMode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse line %s, %w", line, err)
 	}
 
 	return &maraXStatus{
 		mode:            mode,
-		version:         strings.Join(modeVersion[1:], ""),
+		version:         version,
 		steamTemp:       uint16(steamTemp),
 		steamTargetTemp: uint16(steamTargetTemp),
 		hxTemp:          uint16(hxTemp),
 		readyCountdown:  uint16(readyCountdown),
 		heating:         heating,
-	}, err
+		setTemp:         setTemp,
+		pressure:        pressure,
+		timestamp:       timestamp,
+	}, nil
 }
 
-func readLine(rwc io.ReadWriteCloser, timeout time.Duration) ([]byte, error) {
-	b := make(chan []byte)
-	e := make(chan error)
+// isLineControl returns whether r is a control character which is not
+// expected within a line, tabs are tolerated as whitespace around fields.
+func isLineControl(r rune) bool {
+	return unicode.IsControl(r) && r != '\t'
+}
 
-	go func() {
-		reader := bufio.NewReader(rwc)
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			e <- err
-		} else {
-			b <- line
+// parseMode decodes the mode field. With the character encoding only C and V
+// are valid, with the numeric one only 0 and 1. Anything else is most likely
+// a corrupted line.
+func parseMode(field string, numeric bool) (mode, error) {
+	if !numeric {
+		switch field {
+		case coffeeMode:
+			return coffee, nil
+		case steamMode:
+			return steam, nil
 		}
-		close(b)
-		close(e)
-	}()
+		return "", fmt.Errorf("invalid mode %q", field)
+	}
+
+	switch field {
+	case numericCoffeeMode:
+		return coffee, nil
+	case numericSteamMode:
+		return steam, nil
+	}
+	return "", fmt.Errorf("invalid numeric mode %q", field)
+}
+
+// splitTimestamp splits an optional ISO-8601 timestamp, separated by a space,
+// from the start of the line.
+func splitTimestamp(line string) (time.Time, string) {
+	i := strings.IndexByte(line, ' ')
+	if i <= 0 {
+		return time.Time{}, line
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, line[:i])
+	if err != nil {
+		return time.Time{}, line
+	}
+	return timestamp, strings.TrimLeft(line[i+1:], " ")
+}
+
+// readLine reads a single line from the serial port. If the port is a network
+// connection or a pollable serial device the line is read synchronously with
+// a read deadline, without allocations unless ctx can be cancelled. Otherwise
+// a goroutine is used to enforce the timeout. The *os.File of a serial device opened by serial.Open
+// is in blocking mode, its SetReadDeadline succeeds but has no effect.
+//
+// If resync is set the port was just opened, possibly in the middle of a line,
+// so everything up to the first newline is discarded first.
+//
+// Once ctx is done the read is aborted and the error of ctx returned.
+func (collector *maraXCollector) readLine(ctx context.Context) ([]byte, error) {
+	var port readDeadliner
+	switch p := collector.serialPort.(type) {
+	case net.Conn:
+		port = p
+	case *pollableFile:
+		port = p
+	}
+	if port != nil {
+		deadline := time.Now().Add(collector.readTimeout)
+		if err := port.SetReadDeadline(deadline); err == nil {
+			if ctx.Done() != nil {
+				// moving the deadline to now ends a blocked read right away
+				stop := context.AfterFunc(ctx, func() { _ = port.SetReadDeadline(time.Now()) })
+				defer stop()
+			}
+			if collector.reader == nil {
+				collector.reader = bufio.NewReader(collector.serialPort)
+			}
+			if collector.resync {
+				if _, err := readLineDeadline(ctx, collector.reader, deadline, collector.nonBlocking); err != nil {
+					return nil, err
+				}
+				collector.resync = false
+			}
+			return readLineDeadline(ctx, collector.reader, deadline, collector.nonBlocking)
+		}
+	}
+
+	if collector.lines == nil || collector.lines.rwc != collector.serialPort {
+		collector.lines = newLineReader(collector.serialPort)
+	}
+	line, err := collector.lines.readLine(ctx, collector.readTimeout, collector.nonBlocking, collector.resync)
+	if err == nil {
+		collector.resync = false
+	}
+	return line, err
+}
+
+// readDeadliner is implemented by ports supporting read deadlines.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
 
-	select {
-	case line := <-b:
+// readLineDeadline reads a single line from reader which is reading from a
+// port with a read deadline set. The returned line is only valid until the
+// next read from reader.
+func readLineDeadline(ctx context.Context, reader *bufio.Reader, deadline time.Time, nonBlocking bool) ([]byte, error) {
+	for {
+		line, err := reader.ReadSlice('\n')
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if nonBlocking && errors.Is(err, io.EOF) && time.Now().Before(deadline) {
+			time.Sleep(nonBlockingPollInterval)
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || (nonBlocking && errors.Is(err, io.EOF)) {
+			return nil, errReadTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
 		return line, nil
-	case err := <-e:
-		return nil, err
-	case <-time.After(timeout):
-		return nil, errReadTimeout
+	}
+}
+
+// lineReader reads lines from a port without read deadlines, using a
+// goroutine to enforce the timeout. A goroutine blocked reading cannot be
+// stopped until the port returns from the read, so at most one is started at
+// a time: after a timeout the next read waits for the same goroutine instead
+// of starting another one. It only terminates once the port returns from the
+// read, closing a blocking file may even wait for that, so serial devices are
+// made pollable and read with deadlines instead.
+type lineReader struct {
+	rwc    io.ReadWriteCloser
+	reader *bufio.Reader
+	// line is the buffer the lines are read into, it is reused for every
+	// line.
+	line []byte
+	// pending receives the result of the read in flight, if any.
+	pending chan lineResult
+}
+
+// lineResult is the result of a read by a lineReader.
+type lineResult struct {
+	line []byte
+	err  error
+}
+
+func newLineReader(rwc io.ReadWriteCloser) *lineReader {
+	return &lineReader{rwc: rwc, reader: bufio.NewReader(rwc)}
+}
+
+// readLine reads a single line within timeout. If nonBlocking is set, reads
+// returning without data are retried until the timeout is reached. If
+// skipFirst is set, the first line is discarded and the one following it is
+// returned. Once ctx is done, the error of ctx is returned and the read left
+// in flight for the next call. The returned line is only valid until the next
+// call.
+func (r *lineReader) readLine(ctx context.Context, timeout time.Duration, nonBlocking, skipFirst bool) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// a read left over from a previous call may have timed out on its own
+	// already, that does not count towards this one.
+	leftOver := r.pending != nil
+	for {
+		if r.pending == nil {
+			// the channel is buffered so the goroutine can always
+			// terminate, even if we have given up waiting for it.
+			r.pending = make(chan lineResult, 1)
+			go r.read(r.pending, time.Now().Add(timeout), nonBlocking, skipFirst)
+		}
+
+		select {
+		case result := <-r.pending:
+			r.pending = nil
+			if leftOver && errors.Is(result.err, errReadTimeout) {
+				leftOver = false
+				continue
+			}
+			return result.line, result.err
+		case <-timer.C:
+			return nil, errReadTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// read reads a line and sends the result to result.
+func (r *lineReader) read(result chan<- lineResult, deadline time.Time, nonBlocking, skipFirst bool) {
+	line := r.line[:0]
+	for {
+		part, err := r.reader.ReadSlice('\n')
+		line = append(line, part...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// the line is longer than the buffer of the reader
+			continue
+		}
+		if nonBlocking && errors.Is(err, io.EOF) {
+			if time.Now().After(deadline) {
+				result <- lineResult{err: errReadTimeout}
+				return
+			}
+			// no data is available yet, wait a bit before trying again
+			// to not busy-loop on the port.
+			time.Sleep(nonBlockingPollInterval)
+			continue
+		}
+		if err == nil && skipFirst {
+			skipFirst = false
+			line = line[:0]
+			continue
+		}
+		// the next read only starts once the result has been received
+		r.line = line
+		if err != nil {
+			result <- lineResult{err: err}
+		} else {
+			result <- lineResult{line: line}
+		}
+		return
 	}
 }
@@ -1,12 +1,145 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// fakePort is a serial port returning one of its lines on each read. An empty
+// line is returned as a read without data like a non-blocking port does.
+type fakePort struct {
+	lines []string
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if len(p.lines) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.lines[0])
+	p.lines = p.lines[1:]
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *fakePort) Close() error {
+	return nil
+}
+
+// emptyPort is a non-blocking serial port that never has any data.
+type emptyPort struct {
+	fakePort
+}
+
+func (p *emptyPort) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// gather runs a single scrape of the collector and returns the gathered
+// metric families by name.
+func gather(t *testing.T, collector prometheus.Collector) map[string]*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(collector))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	result := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		result[family.GetName()] = family
+	}
+	return result
+}
+
+// counterValue returns the value of the single counter in the named family.
+func counterValue(t *testing.T, families map[string]*dto.MetricFamily, name string) float64 {
+	t.Helper()
+	family, ok := families[name]
+	require.True(t, ok, "metric %s not found", name)
+	require.Len(t, family.GetMetric(), 1)
+	return family.GetMetric()[0].GetCounter().GetValue()
+}
+
+// labelValue returns the value of the label of the single metric in the named
+// family.
+func labelValue(t *testing.T, families map[string]*dto.MetricFamily, name, label string) string {
+	t.Helper()
+	family, ok := families[name]
+	require.True(t, ok, "metric %s not found", name)
+	require.Len(t, family.GetMetric(), 1)
+	for _, pair := range family.GetMetric()[0].GetLabel() {
+		if pair.GetName() == label {
+			return pair.GetValue()
+		}
+	}
+	require.FailNow(t, "label not found", "metric %s has no label %s", name, label)
+	return ""
+}
+
+// waitFor polls the condition until it is true and fails the test if it does
+// not become true within 5 seconds. It evaluates the condition on the test
+// goroutine, require.Eventually of the testify version in use may still run
+// a slow condition after it returned and panic.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, "condition not met within 5s")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// fakeClock is a clock which only moves forward when told to.
+type fakeClock struct {
+	time time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.time
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.time = c.time.Add(d)
+}
+
+// gaugeValue returns the value of the single gauge in the named family.
+func gaugeValue(t *testing.T, families map[string]*dto.MetricFamily, name string) float64 {
+	t.Helper()
+	family, ok := families[name]
+	require.True(t, ok, "metric %s not found", name)
+	require.Len(t, family.GetMetric(), 1)
+	return family.GetMetric()[0].GetGauge().GetValue()
+}
+
 func TestParseLine(t *testing.T) {
 	status, err := parseLine([]byte("C1.23,068,120,054,0820,1"))
 	require.NoError(t, err)
@@ -19,3 +152,2214 @@ func TestParseLine(t *testing.T) {
 	assert.Equal(t, uint16(820), status.readyCountdown)
 	assert.Equal(t, true, status.heating)
 }
+
+func TestParseLineNoise(t *testing.T) {
+	for _, line := range []string{
+		" C1.23, 068,120,054,0820,1",
+		"C1.23,068,120,054,0820,1,",
+		"C1.23 ,068 ,120\t,054,0820, 1 ,",
+	} {
+		status, err := parseLine([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, coffee, status.mode, line)
+		assert.Equal(t, "1.23", status.version, line)
+		assert.Equal(t, uint16(68), status.steamTemp, line)
+		assert.Equal(t, true, status.heating, line)
+	}
+
+	for _, line := range []string{
+		"C1.23,068,120,054,0820,1,,",
+		"C1.23,068,,054,0820,1",
+		"C1.23,068,120,054,0820",
+		",C1.23,068,120,054,0820,1",
+	} {
+		_, err := parseLine([]byte(line))
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	for _, ending := range []string{"", "\n", "\r\n", "\r", "\n\r", "\r\r\n"} {
+		status, err := parseLine([]byte("C1.23,068,120,054,0820,1" + ending))
+		require.NoError(t, err, "%q", ending)
+		assert.Equal(t, true, status.heating, "%q", ending)
+	}
+
+	for _, line := range []string{
+		"C1.23,068,120,054\r,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\rC1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,1\x0020,054,0820,1\r\n",
+	} {
+		_, err := parseLine([]byte(line))
+		require.Error(t, err, "%q", line)
+		assert.Contains(t, err.Error(), "control character", "%q", line)
+	}
+}
+
+func TestParseLineMode(t *testing.T) {
+	defer func() { *numericMode = false }()
+	for _, tc := range []struct {
+		line    string
+		numeric bool
+		mode    mode
+	}{
+		{"C1.23,068,120,054,0820,1", false, coffee},
+		{"V1.23,068,120,054,0820,1", false, steam},
+		{"01.23,068,120,054,0820,1", true, coffee},
+		{"11.23,068,120,054,0820,1", true, steam},
+	} {
+		*numericMode = tc.numeric
+		status, err := parseLine([]byte(tc.line))
+		require.NoError(t, err, tc.line)
+		assert.Equal(t, tc.mode, status.mode, tc.line)
+		assert.Equal(t, "1.23", status.version, tc.line)
+	}
+
+	status, err := parseLine([]byte("21.23,068,120,054,0820,1"))
+	assert.Error(t, err)
+	assert.Nil(t, status)
+
+	// a corrupted mode character is not taken for coffee priority
+	*numericMode = false
+	for _, line := range []string{"X1.23,068,120,054,0820,1", "c1.23,068,120,054,0820,1", "11.23,068,120,054,0820,1"} {
+		status, err := parseLine([]byte(line))
+		require.Error(t, err, line)
+		assert.Contains(t, err.Error(), "invalid mode", line)
+		assert.Nil(t, status, line)
+	}
+}
+
+func TestParseLineWithFormat(t *testing.T) {
+	expected := &maraXStatus{
+		mode:            coffee,
+		version:         "1.23",
+		steamTemp:       68,
+		steamTargetTemp: 120,
+		hxTemp:          54,
+		readyCountdown:  820,
+		heating:         true,
+	}
+
+	status, err := parseLineWithFormat([]byte("C1.23,068,120,054,0820,1\r\n"), *lineFormat)
+	require.NoError(t, err)
+	assert.Equal(t, expected, status)
+
+	format := lineFormatFlag{}
+	require.NoError(t, format.Set("mode_version,hx_temp,steam_temp,steam_target_temp,_,heating,ready_countdown"))
+	status, err = parseLineWithFormat([]byte("C1.23,054,068,120,X,1,0820\r\n"), format)
+	require.NoError(t, err)
+	assert.Equal(t, expected, status)
+
+	// the standard line is one field short of the custom format
+	_, err = parseLineWithFormat([]byte("C1.23,068,120,054,0820,1\r\n"), format)
+	assert.Error(t, err)
+}
+
+func TestTwoLineStatus(t *testing.T) {
+	port := &fakePort{lines: []string{
+		// the second line of a pair which started before the first read
+		"0000,0\r\n",
+		"C1.23,068,120,054\r\n",
+		"0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.twoLineStatus = true
+
+	status, err := collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &maraXStatus{
+		mode:            coffee,
+		version:         "1.23",
+		steamTemp:       68,
+		steamTargetTemp: 120,
+		hxTemp:          54,
+		readyCountdown:  820,
+		heating:         true,
+	}, status)
+
+	// the second line never arrives
+	port.lines = []string{"C1.23,068,120,054\r\n"}
+	_, err = collector.collectDataFromSerial(context.Background())
+	assert.Error(t, err)
+}
+
+func TestParseLineVersion(t *testing.T) {
+	for line, version := range map[string]string{
+		"C1.23,068,120,054,0820,1": "1.23",
+		"V2.0,068,120,054,0820,1":  "2.0",
+	} {
+		status, err := parseLine([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, version, status.version, line)
+	}
+
+	for _, line := range []string{"CX1.23,068,120,054,0820,1", "C1.23b,068,120,054,0820,1", "C1,068,120,054,0820,1"} {
+		status, err := parseLine([]byte(line))
+		assert.Error(t, err, line)
+		assert.Contains(t, err.Error(), "unexpected version format", line)
+		assert.Nil(t, status, line)
+	}
+}
+
+func TestParseLineInvalidHeating(t *testing.T) {
+	for _, line := range []string{"C1.23,068,120,054,0820,2", "C1.23,068,120,054,0820,"} {
+		status, err := parseLine([]byte(line))
+		assert.Error(t, err, line)
+		assert.Nil(t, status, line)
+	}
+}
+
+func TestParseLineTimestamp(t *testing.T) {
+	status, err := parseLine([]byte("2021-03-14T08:30:15.5Z C1.23,068,120,054,0820,1\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 14, 8, 30, 15, 5e8, time.UTC), status.timestamp)
+	assert.Equal(t, "1.23", status.version)
+	assert.Equal(t, uint16(54), status.hxTemp)
+
+	status, err = parseLine([]byte("C1.23,068,120,054,0820,1\r\n"))
+	require.NoError(t, err)
+	assert.True(t, status.timestamp.IsZero())
+
+	collector := newCollector(&fakePort{lines: []string{
+		"2021-03-14T08:30:15Z C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}, serial.OpenOptions{})
+	assert.Equal(t, float64(1615710615), gaugeValue(t, gather(t, collector), "mara_x_line_timestamp_seconds"))
+	assert.NotContains(t, gather(t, collector), "mara_x_line_timestamp_seconds")
+}
+
+func TestParseLineSetTemperature(t *testing.T) {
+	status, err := parseLine([]byte("C1.23,068,120,054,0820,1"))
+	require.NoError(t, err)
+	assert.Nil(t, status.setTemp)
+
+	_, err = parseLine([]byte("C1.23,068,120,054,0820,1,093"))
+	assert.Error(t, err)
+
+	*setTemperature = true
+	defer func() { *setTemperature = false }()
+
+	status, err = parseLine([]byte("C1.23,068,120,054,0820,1,093"))
+	require.NoError(t, err)
+	require.NotNil(t, status.setTemp)
+	assert.Equal(t, uint16(93), *status.setTemp)
+
+	status, err = parseLine([]byte("C1.23,068,120,054,0820,1"))
+	require.NoError(t, err)
+	assert.Nil(t, status.setTemp)
+
+	collector := newCollector(&fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1,093\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}, serial.OpenOptions{})
+	assert.Equal(t, float64(93), gaugeValue(t, gather(t, collector), "mara_x_set_temperature_celsius"))
+	assert.NotContains(t, gather(t, collector), "mara_x_set_temperature_celsius")
+}
+
+func TestParseLinePressure(t *testing.T) {
+	_, err := parseLine([]byte("C1.23,068,120,054,0820,1,9.2"))
+	assert.Error(t, err)
+
+	*pressureField = true
+	defer func() { *pressureField = false }()
+
+	status, err := parseLine([]byte("C1.23,068,120,054,0820,1,9.2"))
+	require.NoError(t, err)
+	require.NotNil(t, status.pressure)
+	assert.Equal(t, 9.2, *status.pressure)
+	assert.Nil(t, status.setTemp)
+
+	status, err = parseLine([]byte("C1.23,068,120,054,0820,1"))
+	require.NoError(t, err)
+	assert.Nil(t, status.pressure)
+
+	_, err = parseLine([]byte("C1.23,068,120,054,0820,1,high"))
+	assert.Error(t, err)
+
+	*setTemperature = true
+	defer func() { *setTemperature = false }()
+
+	status, err = parseLine([]byte("C1.23,068,120,054,0820,1,093,8.7"))
+	require.NoError(t, err)
+	require.NotNil(t, status.setTemp)
+	require.NotNil(t, status.pressure)
+	assert.Equal(t, uint16(93), *status.setTemp)
+	assert.Equal(t, 8.7, *status.pressure)
+
+	collector := newCollector(&fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1,093,8.7\r\n",
+		"C1.23,068,120,054,0820,1,093\r\n",
+	}}, serial.OpenOptions{})
+	assert.Equal(t, 8.7, gaugeValue(t, gather(t, collector), "mara_x_brew_pressure_bar"))
+	assert.NotContains(t, gather(t, collector), "mara_x_brew_pressure_bar")
+}
+
+func TestParseLineEmptyVersion(t *testing.T) {
+	_, err := parseLine([]byte("C,068,120,054,0820,1"))
+	assert.Error(t, err)
+
+	*allowEmptyVersion = true
+	defer func() { *allowEmptyVersion = false }()
+
+	status, err := parseLine([]byte("C,068,120,054,0820,1"))
+	require.NoError(t, err)
+	assert.Equal(t, coffee, status.mode)
+	assert.Equal(t, unknownVersion, status.version)
+
+	_, err = parseLine([]byte(",068,120,054,0820,1"))
+	assert.Error(t, err)
+}
+
+func TestHxTemperatureUnchangedScrapes(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,069,120,054,0810,1\r\n",
+		"C1.23,070,120,054,0800,1\r\n",
+		"C1.23,071,120,055,0790,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	for _, expected := range []float64{0, 1, 2, 0} {
+		families := gather(t, collector)
+		assert.Equal(t, expected, gaugeValue(t, families, "mara_x_hx_temperature_unchanged_scrapes"))
+	}
+}
+
+func TestSerialReadSuccessRatio(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_serial_read_success_ratio"))
+	}
+
+	// the port has no more lines left so this read fails
+	families := gather(t, collector)
+	assert.Equal(t, 0.75, gaugeValue(t, families, "mara_x_serial_read_success_ratio"))
+	assert.NotContains(t, families, "mara_x_hx_temperature_celsius")
+}
+
+func TestSerialReadSuccessRatioBeforeFirstRead(t *testing.T) {
+	port := &blockPort{
+		fakePort: fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}},
+		started:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collector.startPolling(ctx, time.Hour)
+	<-port.started
+
+	// a scrape before the first poll finished has no ratio to report
+	families := gather(t, collector)
+	assert.NotContains(t, families, "mara_x_serial_read_success_ratio")
+	assert.Contains(t, families, "mara_x_up")
+	close(port.release)
+}
+
+func TestReadLineNonBlocking(t *testing.T) {
+	start := time.Now()
+	_, err := newLineReader(&emptyPort{}).readLine(context.Background(), time.Millisecond*100, true, false)
+	assert.Equal(t, errReadTimeout, err)
+	assert.True(t, time.Since(start) < time.Second, "read did not time out")
+
+	line, err := newLineReader(&fakePort{lines: []string{"C1.23,", "", "068,120,054,0820,1\r\n"}}).readLine(context.Background(), time.Second, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, "C1.23,068,120,054,0820,1\r\n", string(line))
+}
+
+// stuckPort blocks every read until it is closed.
+type stuckPort struct {
+	fakePort
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newStuckPort() *stuckPort {
+	return &stuckPort{closed: make(chan struct{})}
+}
+
+func (p *stuckPort) Read(b []byte) (int, error) {
+	<-p.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (p *stuckPort) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func TestReadLineTimeoutGoroutines(t *testing.T) {
+	port := newStuckPort()
+	lines := newLineReader(port)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		_, err := lines.readLine(context.Background(), time.Millisecond, false, false)
+		assert.Equal(t, errReadTimeout, err)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+1)
+
+	// closing the port ends the read in flight
+	require.NoError(t, port.Close())
+	_, err := lines.readLine(context.Background(), time.Second, false, false)
+	assert.Equal(t, io.ErrClosedPipe, err)
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+
+	// the collector closes the port on every timeout and reopens it
+	collector := newCollector(newStuckPort(), serial.OpenOptions{})
+	collector.readTimeout = time.Millisecond
+	collector.open = func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return newStuckPort(), nil
+	}
+	for i := 0; i < 50; i++ {
+		_, err := collector.readSerialLine(context.Background())
+		assert.Error(t, err)
+	}
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= before+1 })
+}
+
+func TestReadsTotal(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"garbage\r\n",
+		"C1.23,068,120,055,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	assert.Equal(t, float64(1), counterValue(t, gather(t, collector), "mara_x_reads_total"))
+	gather(t, collector)
+	assert.Equal(t, float64(2), counterValue(t, gather(t, collector), "mara_x_reads_total"))
+}
+
+func TestReadCancel(t *testing.T) {
+	deadliner, remote := net.Pipe()
+	defer remote.Close()
+	ports := map[string]io.ReadWriteCloser{
+		"goroutine": newStuckPort(),
+		"deadline":  deadliner,
+	}
+	for name, port := range ports {
+		t.Run(name, func(t *testing.T) {
+			collector := newCollector(port, serial.OpenOptions{})
+			collector.readTimeout = time.Minute
+			defer collector.close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(time.Millisecond * 20)
+				cancel()
+			}()
+			start := time.Now()
+			_, err := collector.read(ctx)
+			assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+			assert.Less(t, int64(time.Since(start)), int64(time.Second), "read was not aborted")
+			assert.Equal(t, 0, collector.readErrorsInRow)
+		})
+	}
+}
+
+func TestReadySecondsTotal(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,0000,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	steps := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{0, 0},
+		{time.Second * 10, 10},
+		{time.Second * 5, 15},
+		{time.Second * 20, 15},
+		{time.Second * 3, 18},
+	}
+	for _, step := range steps {
+		clock.add(step.elapsed)
+		assert.Equal(t, step.expected, counterValue(t, gather(t, collector), "mara_x_ready_seconds_total"))
+	}
+}
+
+func TestCollectScaled(t *testing.T) {
+	port := &fakePort{lines: []string{"C1.23,680,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.scales = scaleFlag{"steam_temp": 0.1, "ready_countdown": 2}
+
+	families := gather(t, collector)
+	assert.InDelta(t, 68.0, gaugeValue(t, families, "mara_x_steam_temperature_celsius"), 0.0001)
+	assert.Equal(t, float64(120), gaugeValue(t, families, "mara_x_steam_target_temperature_celsius"))
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(1640), gaugeValue(t, families, "mara_x_ready_countdown"))
+}
+
+func TestDebugHexDumpOnParseFailure(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	slog.SetLogLoggerLevel(slog.LevelDebug)
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+
+	line := "\x00\xffC1.23,068\r\n"
+	collector := newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{})
+	collector.debug = true
+
+	_, err := collector.collectDataFromSerial(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, logs.String(), "DEBUG unable to parse raw line")
+	assert.Contains(t, logs.String(), "00 ff 43 31 2e 32 33 2c  30 36 38 0d 0a")
+}
+
+func TestCollectMillidegrees(t *testing.T) {
+	*tempMillidegrees = true
+	defer func() { *tempMillidegrees = false }()
+
+	port := &fakePort{lines: []string{"C1.23,680,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.scales = scaleFlag{"steam_temp": 0.1}
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(68000), gaugeValue(t, families, "mara_x_steam_temperature_millicelsius"))
+	assert.Equal(t, float64(120000), gaugeValue(t, families, "mara_x_steam_target_temperature_millicelsius"))
+	assert.Equal(t, float64(54000), gaugeValue(t, families, "mara_x_hx_temperature_millicelsius"))
+	assert.NotContains(t, families, "mara_x_hx_temperature_celsius")
+	assert.Equal(t, float64(820), gaugeValue(t, families, "mara_x_ready_countdown"))
+}
+
+func TestCollectFahrenheit(t *testing.T) {
+	line := "C1.23,100,120,054,0820,1\r\n"
+	families := gather(t, newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{}))
+	assert.Equal(t, float64(100), gaugeValue(t, families, "mara_x_steam_temperature_celsius"))
+	assert.NotContains(t, families, "mara_x_steam_temperature_fahrenheit")
+
+	*units = unitsFahrenheit
+	defer func() { *units = unitsCelsius }()
+
+	families = gather(t, newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{}))
+	assert.Equal(t, float64(212), gaugeValue(t, families, "mara_x_steam_temperature_fahrenheit"))
+	assert.Equal(t, float64(248), gaugeValue(t, families, "mara_x_steam_target_temperature_fahrenheit"))
+	assert.InDelta(t, 129.2, gaugeValue(t, families, "mara_x_hx_temperature_fahrenheit"), 0.0001)
+	assert.NotContains(t, families, "mara_x_steam_temperature_celsius")
+	assert.Equal(t, float64(820), gaugeValue(t, families, "mara_x_ready_countdown"))
+}
+
+func TestConfiguredReadTimeout(t *testing.T) {
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_configured_read_timeout_seconds"))
+
+	collector.readTimeout = time.Millisecond * 2500
+	assert.Equal(t, 2.5, gaugeValue(t, gather(t, collector), "mara_x_configured_read_timeout_seconds"))
+}
+
+// partialPort returns a line without its newline and then blocks.
+type partialPort struct {
+	fakePort
+	read bool
+}
+
+func (p *partialPort) Read(b []byte) (int, error) {
+	if p.read {
+		select {}
+	}
+	p.read = true
+	return copy(b, "C1.23,068,120"), nil
+}
+
+func TestReadTimeoutFlag(t *testing.T) {
+	*readTimeout = time.Millisecond * 50
+	*warmupReads = 0
+	defer func() {
+		*readTimeout = defaultReadTimeout
+		*warmupReads = 3
+	}()
+
+	collector, err := newMaraXCollector(func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return &partialPort{}, nil
+	}, *serialDevice)
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond*50, collector.readTimeout)
+
+	start := time.Now()
+	_, err = collector.readLine(context.Background())
+	assert.Equal(t, errReadTimeout, err)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= time.Millisecond*50 && elapsed < defaultReadTimeout, "timed out after %s", elapsed)
+}
+
+func TestCollectDataFromSerialOpener(t *testing.T) {
+	*warmupReads = 0
+	defer func() { *warmupReads = 3 }()
+	// the port is opened in the middle of a line which is dropped
+	canned := []byte("0,1\r\nC1.23,068,120,054,0820,1\r\nV1.23,070,125,061,0000,0\r\n")
+	var opened []serial.OpenOptions
+	collector, err := newMaraXCollector(func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		opened = append(opened, options)
+		return readerPort{Reader: bytes.NewReader(canned)}, nil
+	}, "/dev/ttyUSB1")
+	require.NoError(t, err)
+	require.Len(t, opened, 1)
+	assert.Equal(t, "/dev/ttyUSB1", opened[0].PortName)
+
+	status, err := collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &maraXStatus{
+		mode: coffee, version: "1.23", steamTemp: 68, steamTargetTemp: 120, hxTemp: 54, readyCountdown: 820, heating: true,
+	}, status)
+
+	status, err = collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &maraXStatus{
+		mode: steam, version: "1.23", steamTemp: 70, steamTargetTemp: 125, hxTemp: 61, readyCountdown: 0, heating: false,
+	}, status)
+}
+
+func TestConfigMetrics(t *testing.T) {
+	*readTimeout = time.Millisecond * 1500
+	*readRetries = 4
+	*maxStaleness = time.Minute
+	*warmupReads = 0
+	defer func() {
+		*readTimeout = defaultReadTimeout
+		*readRetries = defaultReadRetries
+		*maxStaleness = 0
+		*warmupReads = 3
+	}()
+
+	collector, err := newMaraXCollector(func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n", "C1.23,068,120,054,0820,1\r\n"}}, nil
+	}, *serialDevice)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collector.startPolling(ctx, time.Hour)
+	waitFor(t, func() bool {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		return collector.previous != nil
+	})
+
+	families := gather(t, collector)
+	assert.Equal(t, 1.5, gaugeValue(t, families, "mara_x_configured_read_timeout_seconds"))
+	assert.Equal(t, float64(3600), gaugeValue(t, families, "mara_x_config_poll_interval_seconds"))
+	assert.Equal(t, float64(4), gaugeValue(t, families, "mara_x_config_read_retries"))
+	assert.Equal(t, float64(60), gaugeValue(t, families, "mara_x_config_max_staleness_seconds"))
+}
+
+func TestResyncAfterOpen(t *testing.T) {
+	port := &fakePort{lines: []string{"23,054,0820,1\nC1.23,068,120,054,0820,1\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.resync = true
+
+	status, err := collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(54), status.hxTemp)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	collector = newCollector(local, serial.OpenOptions{})
+	collector.resync = true
+	go func() {
+		_, _ = remote.Write([]byte("23,054,0820,1\nC1.23,068,120,054,0820,1\n"))
+	}()
+	line, err := collector.readLine(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "C1.23,068,120,054,0820,1\n", string(line))
+}
+
+func TestModeDebounce(t *testing.T) {
+	modes := []string{"C", "V", "C", "C", "V", "V", "V"}
+	expected := []mode{coffee, coffee, coffee, coffee, coffee, coffee, steam}
+
+	port := &fakePort{}
+	for _, m := range modes {
+		port.lines = append(port.lines, m+"1.23,068,120,054,0820,1\r\n")
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.modeDebounce = 3
+
+	for _, m := range expected {
+		assert.Equal(t, string(m), labelValue(t, gather(t, collector), "mara_x_info", "mode"))
+	}
+}
+
+func TestOmitZeroCountdown(t *testing.T) {
+	port := &fakePort{}
+	for _, countdown := range []string{"0005", "0000", "0000", "0000", "0000", "0003"} {
+		port.lines = append(port.lines, "C1.23,068,120,054,"+countdown+",1\r\n")
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.omitZeroCountdown = 2
+
+	for _, exposed := range []bool{true, true, true, false, false, true} {
+		families := gather(t, collector)
+		if exposed {
+			assert.Contains(t, families, "mara_x_ready_countdown")
+		} else {
+			assert.NotContains(t, families, "mara_x_ready_countdown")
+		}
+		assert.Contains(t, families, "mara_x_hx_temperature_celsius")
+	}
+}
+
+func TestConsolidatedInfo(t *testing.T) {
+	*consolidatedInfo = true
+	*machineAlias = "kitchen"
+	defer func() {
+		*consolidatedInfo = false
+		*machineAlias = ""
+	}()
+
+	port := &fakePort{lines: []string{"V1.23,068,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{PortName: "/dev/ttyS0", BaudRate: 9600})
+
+	families := gather(t, collector)
+	assert.NotContains(t, families, "mara_x_info")
+	for label, expected := range map[string]string{
+		"version":       "1.23",
+		"mode":          "steam",
+		"serial_device": "/dev/ttyS0",
+		"baud":          "9600",
+		"alias":         "kitchen",
+	} {
+		assert.Equal(t, expected, labelValue(t, families, "mara_x_machine_info", label))
+	}
+}
+
+func TestReadLineDeadline(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	collector := newCollector(local, serial.OpenOptions{})
+	collector.readTimeout = time.Millisecond * 50
+
+	go func() {
+		_, _ = remote.Write([]byte("C1.23,068,120,054,0820,1\r\n"))
+	}()
+	line, err := collector.readLine(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "C1.23,068,120,054,0820,1\r\n", string(line))
+
+	_, err = collector.readLine(context.Background())
+	assert.Equal(t, errReadTimeout, err)
+}
+
+// writeLines writes lines to w until it is closed.
+func writeLines(w io.Writer) {
+	line := []byte("C1.23,068,120,054,0820,1\r\n")
+	for {
+		if _, err := w.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+func TestReadLineDeadlineAllocs(t *testing.T) {
+	// unlike a net.Pipe, setting the deadline of a TCP connection does not
+	// allocate
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		remote, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer remote.Close()
+		writeLines(remote)
+	}()
+	local, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer local.Close()
+
+	collector := newCollector(local, serial.OpenOptions{})
+	_, err = collector.readLine(context.Background())
+	require.NoError(t, err)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := collector.readLine(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func BenchmarkReadLine(b *testing.B) {
+	b.Run("goroutine", func(b *testing.B) {
+		local, remote := net.Pipe()
+		defer local.Close()
+		go writeLines(remote)
+
+		lines := newLineReader(local)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := lines.readLine(context.Background(), time.Second, false, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("deadline", func(b *testing.B) {
+		local, remote := net.Pipe()
+		defer local.Close()
+		go writeLines(remote)
+		collector := newCollector(local, serial.OpenOptions{})
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := collector.readLine(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestHxTemperatureModeAverage(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,090,0000,1\r\n",
+		"C1.23,068,120,094,0000,1\r\n",
+		"V1.23,068,120,100,0000,1\r\n",
+		"V1.23,068,120,104,0000,1\r\n",
+		"V1.23,068,120,105,0000,1\r\n",
+		"C1.23,068,120,093,0000,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	var families map[string]*dto.MetricFamily
+	for i := 0; i < 5; i++ {
+		families = gather(t, collector)
+	}
+	averages := map[string]float64{}
+	for _, metric := range families["mara_x_hx_temperature_mode_avg"].GetMetric() {
+		averages[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"coffee": 92, "steam": 103}, averages)
+
+	rec := httptest.NewRecorder()
+	collector.resetHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reset", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	collector.resetHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	families = gather(t, collector)
+	require.Len(t, families["mara_x_hx_temperature_mode_avg"].GetMetric(), 1)
+	assert.Equal(t, "coffee", labelValue(t, families, "mara_x_hx_temperature_mode_avg", "mode"))
+	assert.Equal(t, float64(93), gaugeValue(t, families, "mara_x_hx_temperature_mode_avg"))
+}
+
+func TestGoroutinesMetric(t *testing.T) {
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_goroutines")
+
+	collector.exposeGoroutines = true
+	assert.Greater(t, gaugeValue(t, gather(t, collector), "mara_x_goroutines"), float64(0))
+}
+
+func TestParseRetries(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"23,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(1), counterValue(t, families, "mara_x_parse_retries_total"))
+
+	families = gather(t, collector)
+	assert.Equal(t, float64(1), counterValue(t, families, "mara_x_parse_retries_total"))
+}
+
+func TestReadLatencySummary(t *testing.T) {
+	port := &fakePort{}
+	for i := 0; i < 5; i++ {
+		port.lines = append(port.lines, "C1.23,068,120,054,0820,1\r\n")
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	for i := 0; i < 4; i++ {
+		_, err := collector.readSerialLine(context.Background())
+		require.NoError(t, err)
+	}
+
+	// the scrape reads the fifth line
+	summary := gather(t, collector)["mara_x_serial_read_latency_seconds"].GetMetric()[0].GetSummary()
+	assert.Equal(t, uint64(5), summary.GetSampleCount())
+	var quantiles []float64
+	for _, quantile := range summary.GetQuantile() {
+		quantiles = append(quantiles, quantile.GetQuantile())
+		assert.GreaterOrEqual(t, quantile.GetValue(), float64(0))
+		assert.LessOrEqual(t, quantile.GetValue(), summary.GetSampleSum())
+	}
+	assert.Equal(t, []float64{0.5, 0.9, 0.99}, quantiles)
+}
+
+func TestMinReadInterval(t *testing.T) {
+	port := &fakePort{}
+	for i := 0; i < 5; i++ {
+		port.lines = append(port.lines, "C1.23,068,120,054,0820,1\r\n")
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+	collector.minReadInterval = time.Second
+
+	for i := 0; i < 5; i++ {
+		families := gather(t, collector)
+		assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+		assert.Equal(t, float64(1), counterValue(t, families, "mara_x_reads_total"))
+		clock.add(time.Millisecond * 100)
+	}
+	assert.Len(t, port.lines, 4)
+
+	clock.add(time.Second)
+	assert.Equal(t, float64(2), counterValue(t, gather(t, collector), "mara_x_reads_total"))
+	assert.Len(t, port.lines, 3)
+}
+
+func TestReadRetries(t *testing.T) {
+	// the first read times out even after reopening the port, the retry
+	// then reads from the port opened next
+	opened := []io.ReadWriteCloser{
+		newStuckPort(),
+		&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}},
+	}
+	first := newStuckPort()
+	defer first.Close()
+	collector := newCollector(first, serial.OpenOptions{})
+	collector.readTimeout = time.Millisecond * 20
+	collector.open = func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		port := opened[0]
+		opened = opened[1:]
+		return port, nil
+	}
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(1), counterValue(t, families, "mara_x_parse_retries_total"))
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_serial_read_success_ratio"))
+
+	collector = newCollector(&emptyPort{}, serial.OpenOptions{})
+	collector.readRetries = 0
+	families = gather(t, collector)
+	assert.NotContains(t, families, "mara_x_hx_temperature_celsius")
+	assert.Equal(t, float64(0), counterValue(t, families, "mara_x_parse_retries_total"))
+}
+
+func TestSensorFault(t *testing.T) {
+	sensorFault := func(families map[string]*dto.MetricFamily) map[string]float64 {
+		faults := map[string]float64{}
+		for _, metric := range families["mara_x_sensor_fault"].GetMetric() {
+			faults[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+		return faults
+	}
+
+	for name, tc := range map[string]struct {
+		line   string
+		faults map[string]float64
+	}{
+		"in range":     {"C1.23,116,120,095,0000,1\r\n", map[string]float64{"steam_temp": 0, "hx_temp": 0}},
+		"low outlier":  {"C1.23,116,120,000,0000,1\r\n", map[string]float64{"steam_temp": 0, "hx_temp": 1}},
+		"high outlier": {"C1.23,255,120,095,0000,1\r\n", map[string]float64{"steam_temp": 1, "hx_temp": 0}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			collector := newCollector(&fakePort{lines: []string{tc.line}}, serial.OpenOptions{})
+			collector.sensorRanges = map[string]rangeFlag{
+				"steam_temp": {min: 1, max: 180, set: true},
+				"hx_temp":    {min: 1, max: 180, set: true},
+			}
+			sink := &fakeSink{}
+			collector.sinks = append(collector.sinks, sink)
+
+			families := gather(t, collector)
+			assert.Equal(t, tc.faults, sensorFault(families))
+			faulty := tc.faults["steam_temp"] == 1 || tc.faults["hx_temp"] == 1
+			assert.Equal(t, !faulty, families["mara_x_hx_temperature_celsius"] != nil)
+			assert.Equal(t, faulty, collector.previous == nil)
+			assert.Equal(t, !faulty, len(sink.statuses) == 1)
+		})
+	}
+}
+
+func TestModeGauge(t *testing.T) {
+	for line, expected := range map[string]float64{
+		"C1.23,068,120,054,0820,1\r\n": 0,
+		"V1.23,068,120,054,0820,1\r\n": 1,
+	} {
+		families := gather(t, newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{}))
+		assert.Equal(t, expected, gaugeValue(t, families, "mara_x_mode"), line)
+		assert.Contains(t, families, "mara_x_info")
+	}
+}
+
+func TestReady(t *testing.T) {
+	for countdown, ready := range map[uint16]float64{0: 1, 820: 0} {
+		line := fmt.Sprintf("C1.23,116,120,095,%04d,1\r\n", countdown)
+		families := gather(t, newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{}))
+		assert.Equal(t, ready, gaugeValue(t, families, "mara_x_ready"), line)
+		assert.Equal(t, float64(countdown), gaugeValue(t, families, "mara_x_ready_countdown"), line)
+	}
+}
+
+func TestLastReadTimestamp(t *testing.T) {
+	port := &fakePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(1600000000, 500000000)}
+	collector.now = clock.now
+
+	assert.NotContains(t, gather(t, collector), "mara_x_last_read_timestamp_seconds")
+
+	port.lines = []string{"C1.23,068,120,054,0820,1\r\n"}
+	assert.Equal(t, 1600000000.5, gaugeValue(t, gather(t, collector), "mara_x_last_read_timestamp_seconds"))
+
+	// a failed read keeps the time of the last successful one
+	clock.add(time.Second * 30)
+	assert.Equal(t, 1600000000.5, gaugeValue(t, gather(t, collector), "mara_x_last_read_timestamp_seconds"))
+}
+
+func TestFailedScrapeSelfMetrics(t *testing.T) {
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_up"))
+	assert.Equal(t, float64(1), counterValue(t, families, "mara_x_scrapes_total"))
+	assert.Contains(t, families, "mara_x_hx_temperature_celsius")
+
+	families = gather(t, collector)
+	assert.Equal(t, float64(0), gaugeValue(t, families, "mara_x_up"))
+	assert.Equal(t, float64(2), counterValue(t, families, "mara_x_scrapes_total"))
+	assert.Equal(t, 0.5, gaugeValue(t, families, "mara_x_serial_read_success_ratio"))
+	assert.Contains(t, families, "mara_x_read_errors_total")
+	assert.NotContains(t, families, "mara_x_hx_temperature_celsius")
+	assert.NotContains(t, families, "mara_x_info")
+
+	collector.failedSelfMetrics = false
+	assert.Empty(t, gather(t, collector))
+}
+
+func TestMaxStaleness(t *testing.T) {
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.maxStaleness = time.Second * 30
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(0), gaugeValue(t, families, "mara_x_data_stale"))
+
+	// the read fails, the previous reading is served as stale
+	clock.add(time.Second * 30)
+	families = gather(t, collector)
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_data_stale"))
+	assert.Equal(t, float64(0), gaugeValue(t, families, "mara_x_up"))
+
+	clock.add(time.Second)
+	families = gather(t, collector)
+	assert.NotContains(t, families, "mara_x_hx_temperature_celsius")
+	assert.NotContains(t, families, "mara_x_data_stale")
+	assert.Contains(t, families, "mara_x_up")
+}
+
+func TestStructuredMetricNames(t *testing.T) {
+	line := "C1.23,068,120,054,0820,1\r\n"
+	families := gather(t, newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{}))
+	for _, name := range []string{
+		"mara_x_info", "mara_x_hx_temperature_celsius", "mara_x_heating",
+		"mara_x_serial_read_success_ratio", "mara_x_parse_retries_total",
+	} {
+		assert.Contains(t, families, name)
+	}
+
+	*structuredNames = true
+	defer func() { *structuredNames = false }()
+
+	families = gather(t, newCollector(&fakePort{lines: []string{line}}, serial.OpenOptions{}))
+	for _, name := range []string{
+		"marax_info", "marax_boiler_hx_temperature_celsius", "marax_boiler_heating",
+		"marax_serial_read_success_ratio", "marax_serial_parse_retries_total",
+	} {
+		assert.Contains(t, families, name)
+	}
+	for name := range families {
+		assert.NotContains(t, name, "mara_x_")
+	}
+}
+
+func TestMetricPrefix(t *testing.T) {
+	*metricPrefix = "espresso"
+	defer func() { *metricPrefix = defaultMetricPrefix }()
+
+	collector := newCollector(&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, serial.OpenOptions{})
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		collector.Describe(descs)
+		close(descs)
+	}()
+	for desc := range descs {
+		assert.Contains(t, desc.String(), `fqName: "espresso_`)
+	}
+
+	families := gather(t, collector)
+	assert.Contains(t, families, "espresso_hx_temperature_celsius")
+
+	*structuredNames = true
+	defer func() { *structuredNames = false }()
+	families = gather(t, newCollector(&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, serial.OpenOptions{}))
+	assert.Contains(t, families, "espresso_boiler_hx_temperature_celsius")
+}
+
+func TestLogReadingsSample(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	port := &fakePort{}
+	for i := 0; i < 10; i++ {
+		port.lines = append(port.lines, fmt.Sprintf("C1.23,068,120,%03d,0820,1\r\n", 50+i))
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.logReadingsSample = 3
+	for i := 0; i < 10; i++ {
+		gather(t, collector)
+	}
+
+	assert.Equal(t, 4, strings.Count(logs.String(), "INFO read status "))
+	for _, hxTemp := range []string{"hx_temp=50 ", "hx_temp=53 ", "hx_temp=56 ", "hx_temp=59 "} {
+		assert.Contains(t, logs.String(), hxTemp)
+	}
+}
+
+func TestCustomOpener(t *testing.T) {
+	var opened []serial.OpenOptions
+	open := func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		opened = append(opened, options)
+		// the partial line after opening is skipped and the first full line
+		// is discarded during warm-up
+		return &fakePort{lines: []string{"0820,1\r\n", "C1.23,068,120,054,0820,1\r\n", "C1.23,068,120,054,0820,1\r\n"}}, nil
+	}
+
+	collector, err := newMaraXCollector(open, *serialDevice)
+	require.NoError(t, err)
+	require.Len(t, opened, 1)
+	assert.Equal(t, *serialDevice, opened[0].PortName)
+
+	status, err := collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(54), status.hxTemp)
+
+	// a timeout reopens the port with the opener
+	collector.serialPort = &emptyPort{}
+	collector.nonBlocking = true
+	collector.readTimeout = time.Millisecond
+	status, err = collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(54), status.hxTemp)
+	assert.Len(t, opened, 2)
+}
+
+func TestFlowControl(t *testing.T) {
+	var opened serial.OpenOptions
+	open := func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		opened = options
+		return &fakePort{lines: []string{"0820,1\r\n", "C1.23,068,120,054,0820,1\r\n"}}, nil
+	}
+
+	_, err := newMaraXCollector(open, *serialDevice)
+	require.NoError(t, err)
+	assert.False(t, opened.RTSCTSFlowControl)
+
+	*flowControl = flowControlRTSCTS
+	defer func() { *flowControl = flowControlNone }()
+
+	_, err = newMaraXCollector(open, *serialDevice)
+	require.NoError(t, err)
+	assert.True(t, opened.RTSCTSFlowControl)
+}
+
+func TestMultipleDevices(t *testing.T) {
+	lines := map[string]string{
+		"/dev/ttyUSB0": "C1.23,068,120,054,0820,1\r\n",
+		"/dev/ttyUSB1": "V1.23,098,120,091,0000,0\r\n",
+		"/dev/ttyUSB2": "",
+	}
+	open := func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		line := lines[options.PortName]
+		if line == "" {
+			return nil, errors.New("no such device")
+		}
+		return &fakePort{lines: []string{"0820,1\r\n", line, line}}, nil
+	}
+
+	collectors, err := newMaraXCollectors(open, []string{"/dev/ttyUSB0", "/dev/ttyUSB1", "/dev/ttyUSB2"})
+	require.NoError(t, err)
+	require.Len(t, collectors, 2)
+
+	reg := prometheus.NewRegistry()
+	registerCollectors(reg, collectors)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	temps := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "mara_x_hx_temperature_celsius" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "device" {
+					temps[label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{"/dev/ttyUSB0": 54, "/dev/ttyUSB1": 91}, temps)
+
+	_, err = newMaraXCollectors(open, []string{"/dev/ttyUSB2"})
+	assert.Error(t, err)
+}
+
+func TestConstLabels(t *testing.T) {
+	require.NoError(t, constLabels.Set("location=kitchen"))
+	defer delete(constLabels, "location")
+
+	collector := newCollector(&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, serial.OpenOptions{})
+	reg := prometheus.NewRegistry()
+	registerCollectors(reg, []*maraXCollector{collector})
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, families)
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			assert.Equal(t, "kitchen", labels["location"], family.GetName())
+		}
+	}
+}
+
+func TestSerialOpenOptions(t *testing.T) {
+	settings := serialSettings{
+		device:   "/dev/ttyUSB0",
+		baud:     19200,
+		dataBits: 7,
+		stopBits: 2,
+		minRead:  8,
+		rtscts:   true,
+	}
+	assert.Equal(t, serial.OpenOptions{
+		PortName:          "/dev/ttyUSB0",
+		BaudRate:          19200,
+		DataBits:          7,
+		StopBits:          2,
+		MinimumReadSize:   8,
+		RTSCTSFlowControl: true,
+	}, settings.openOptions())
+
+	settings.nonBlocking = true
+	options := settings.openOptions()
+	assert.Equal(t, uint(0), options.MinimumReadSize)
+	assert.Equal(t, uint(100), options.InterCharacterTimeout)
+}
+
+func TestParseDuration(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"23,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	gather(t, collector)
+	families := gather(t, collector)
+	family, ok := families["mara_x_parse_duration_seconds"]
+	require.True(t, ok)
+	assert.Equal(t, uint64(3), family.GetMetric()[0].GetHistogram().GetSampleCount())
+}
+
+// slowPort delays every read.
+type slowPort struct {
+	fakePort
+	delay time.Duration
+}
+
+func (p *slowPort) Read(b []byte) (int, error) {
+	time.Sleep(p.delay)
+	return p.fakePort.Read(b)
+}
+
+func TestReadDuration(t *testing.T) {
+	port := &slowPort{
+		fakePort: fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}},
+		delay:    time.Millisecond * 50,
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	histogram := gather(t, collector)["mara_x_serial_read_duration_seconds"].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(1), histogram.GetSampleCount())
+	assert.GreaterOrEqual(t, histogram.GetSampleSum(), 0.05)
+
+	// reads which time out are observed too, including the retry after
+	// reopening the port
+	timeout := &blockPort{started: make(chan struct{}), release: make(chan struct{})}
+	collector = newCollector(timeout, serial.OpenOptions{})
+	collector.readTimeout = time.Millisecond * 20
+	collector.open = func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return timeout, nil
+	}
+	_, err := collector.readSerialLine(context.Background())
+	assert.True(t, errors.Is(err, errReadTimeout))
+
+	histogram = gather(t, collector)["mara_x_serial_read_duration_seconds"].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(4), histogram.GetSampleCount())
+	assert.GreaterOrEqual(t, histogram.GetSampleSum(), 0.04)
+}
+
+func TestStartupCheck(t *testing.T) {
+	lines := []string{"C1.23,068,120,090,0820,1\r\n", "C1.23,068,120,120,0820,1\r\n"}
+
+	collector := newCollector(&fakePort{lines: lines}, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_startup_check_passed")
+
+	collector = newCollector(&fakePort{lines: lines}, serial.OpenOptions{})
+	require.NoError(t, collector.expectHxRange.Set("80-100"))
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_startup_check_passed"))
+	// only the first reading is checked
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_startup_check_passed"))
+
+	collector = newCollector(&fakePort{lines: lines[1:]}, serial.OpenOptions{})
+	require.NoError(t, collector.expectHxRange.Set("80-100"))
+	assert.Equal(t, float64(0), gaugeValue(t, gather(t, collector), "mara_x_startup_check_passed"))
+}
+
+func TestBaudMismatchSuspected(t *testing.T) {
+	garbage := make([]byte, 32)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	for i, b := range garbage {
+		if b == '\n' {
+			garbage[i] = 0xff
+		}
+	}
+	line := string(garbage) + "\n"
+
+	port := &fakePort{lines: []string{line, line, line, "C1.23,068,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_baud_mismatch_suspected"))
+
+	families = gather(t, collector)
+	assert.Equal(t, float64(0), gaugeValue(t, families, "mara_x_baud_mismatch_suspected"))
+}
+
+func TestSecondsSinceReady(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,116,120,095,0000,0\r\n",
+		"C1.23,116,120,095,0000,0\r\n",
+		"C1.23,116,120,095,0820,1\r\n",
+		"C1.23,116,120,095,0810,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	for _, expected := range []float64{0, 0, 10, 20} {
+		assert.Equal(t, expected, gaugeValue(t, gather(t, collector), "mara_x_seconds_since_ready"))
+		clock.add(time.Second * 10)
+	}
+
+	collector = newCollector(&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_seconds_since_ready")
+}
+
+func TestNotHeatingSecondsTotal(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,0\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,0\r\n",
+		"C1.23,068,120,054,0820,0\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	assert.NotContains(t, gather(t, collector), "mara_x_not_heating_seconds_total")
+	collector.trackNotHeating = true
+
+	steps := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{time.Second * 10, 10},
+		{time.Second * 5, 10},
+		{time.Second * 20, 10},
+		{time.Second * 3, 13},
+	}
+	for _, step := range steps {
+		clock.add(step.elapsed)
+		assert.Equal(t, step.expected, counterValue(t, gather(t, collector), "mara_x_not_heating_seconds_total"))
+	}
+}
+
+func TestHeatingOnSecondsTotal(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,0\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,0\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	assert.Equal(t, float64(0), counterValue(t, gather(t, collector), "mara_x_heating_on_seconds_total"))
+	steps := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{time.Second * 10, 10},
+		{time.Second * 5, 15},
+		{time.Second * 20, 15},
+		{time.Second * 3, 18},
+	}
+	for _, step := range steps {
+		clock.add(step.elapsed)
+		assert.Equal(t, step.expected, counterValue(t, gather(t, collector), "mara_x_heating_on_seconds_total"))
+	}
+}
+
+func TestTempAnomaly(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	port := &fakePort{lines: []string{
+		"C1.23,118,120,054,0820,1\r\n",
+		"C1.23,150,120,054,0820,1\r\n",
+		"C1.23,152,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_temp_anomaly")
+	collector.tempAnomalyMargin = 10
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_temp_anomaly"))
+	// the data is still exposed
+	assert.Equal(t, float64(150), gaugeValue(t, families, "mara_x_steam_temperature_celsius"))
+	gather(t, collector)
+	assert.Equal(t, 1, strings.Count(logs.String(), "steam temperature exceeds its target"))
+
+	port.lines = []string{"C1.23,125,120,054,0820,1\r\n"}
+	assert.Equal(t, float64(0), gaugeValue(t, gather(t, collector), "mara_x_temp_anomaly"))
+}
+
+func TestTempAlertLevel(t *testing.T) {
+	port := &fakePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+	port.lines = []string{"C1.23,150,120,054,0820,1\r\n"}
+	assert.NotContains(t, gather(t, collector), "mara_x_temp_alert_level")
+
+	collector.steamTempWarn = 140
+	collector.steamTempCrit = 160
+	for _, tc := range []struct {
+		steamTemp int
+		level     float64
+	}{
+		{120, alertOK},
+		{139, alertOK},
+		{140, alertWarn},
+		{159, alertWarn},
+		{160, alertCrit},
+		{170, alertCrit},
+		{125, alertOK},
+	} {
+		port.lines = []string{fmt.Sprintf("C1.23,%03d,120,054,0820,1\r\n", tc.steamTemp)}
+		assert.Equal(t, tc.level, gaugeValue(t, gather(t, collector), "mara_x_temp_alert_level"), tc.steamTemp)
+	}
+
+	// only a critical threshold
+	collector.steamTempWarn = 0
+	port.lines = []string{"C1.23,150,120,054,0820,1\r\n"}
+	assert.Equal(t, float64(alertOK), gaugeValue(t, gather(t, collector), "mara_x_temp_alert_level"))
+}
+
+func TestReadySecondsEstimate(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,1500,1\r\n",
+		"C1.23,068,120,055,1490,1\r\n",
+		"C1.23,068,120,056,1470,1\r\n",
+		"C1.23,068,120,058,1470,1\r\n",
+		"C1.23,068,120,060,1500,1\r\n",
+		"C1.23,068,120,093,0000,0\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	steps := []struct {
+		present  bool
+		expected float64
+	}{
+		// ready
+		{true, 0},
+		// the countdown did not decrease yet
+		{false, 0},
+		// 10 per 5s
+		{true, 745},
+		// 30 per 10s
+		{true, 490},
+		// 30 per 15s
+		{true, 735},
+		// a new cycle starts over
+		{false, 0},
+		{true, 0},
+	}
+	for i, step := range steps {
+		families := gather(t, collector)
+		if step.present {
+			assert.Equal(t, step.expected, gaugeValue(t, families, "mara_x_ready_seconds_estimate"), "step %d", i)
+		} else {
+			assert.NotContains(t, families, "mara_x_ready_seconds_estimate", "step %d", i)
+		}
+		clock.add(time.Second * 5)
+	}
+}
+
+func TestHxTemperatureHistogram(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,084,0000,1\r\n",
+		"C1.23,068,120,091,0000,1\r\n",
+		"C1.23,068,120,093,0000,1\r\n",
+		"C1.23,068,120,120,0000,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_hx_temperature_distribution_celsius")
+	collector.hxTempHistogram = true
+
+	// the temperatures are observed even while the histogram is not exposed
+	var families map[string]*dto.MetricFamily
+	for i := 0; i < 3; i++ {
+		families = gather(t, collector)
+	}
+	family, ok := families["mara_x_hx_temperature_distribution_celsius"]
+	require.True(t, ok)
+	histogram := family.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(4), histogram.GetSampleCount())
+	assert.Equal(t, float64(84+91+93+120), histogram.GetSampleSum())
+
+	counts := make(map[float64]uint64)
+	for _, bucket := range histogram.GetBucket() {
+		counts[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+	}
+	assert.Equal(t, uint64(0), counts[80])
+	assert.Equal(t, uint64(1), counts[85])
+	assert.Equal(t, uint64(1), counts[90])
+	assert.Equal(t, uint64(2), counts[92])
+	assert.Equal(t, uint64(3), counts[94])
+	assert.Equal(t, uint64(3), counts[110])
+}
+
+// fakeSink records all emitted statuses.
+type fakeSink struct {
+	statuses []*maraXStatus
+}
+
+func (s *fakeSink) Emit(status *maraXStatus) {
+	s.statuses = append(s.statuses, status)
+}
+
+func TestSinks(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"garbage\r\n", "garbage\r\n", "garbage\r\n",
+		"V1.23,070,120,056,0000,0\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	sink := &fakeSink{}
+	collector.sinks = append(collector.sinks, sink)
+
+	for i := 0; i < 3; i++ {
+		gather(t, collector)
+	}
+
+	require.Len(t, sink.statuses, 2)
+	assert.Equal(t, uint16(54), sink.statuses[0].hxTemp)
+	assert.Equal(t, steam, sink.statuses[1].mode)
+	assert.Equal(t, uint16(56), sink.statuses[1].hxTemp)
+}
+
+func TestDuplicateLines(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,055,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	for _, expected := range []float64{0, 1, 2, 2, 2} {
+		assert.Equal(t, expected, counterValue(t, gather(t, collector), "mara_x_duplicate_lines_total"))
+	}
+}
+
+func TestReadyCountdownInitial(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,1480,1\r\n",
+		"C1.23,068,120,054,1500,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0000,0\r\n",
+		"C1.23,068,120,054,0900,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	assert.NotContains(t, gather(t, collector), "mara_x_ready_countdown_initial")
+	for _, expected := range []float64{1480, 1500, 1500, 1500, 900} {
+		assert.Equal(t, expected, gaugeValue(t, gather(t, collector), "mara_x_ready_countdown_initial"))
+	}
+}
+
+func TestSteamTemperatureHelp(t *testing.T) {
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	families := gather(t, newCollector(port, serial.OpenOptions{}))
+
+	assert.Equal(t, "The current steam temperature.", families["mara_x_steam_temperature_celsius"].GetHelp())
+	assert.Equal(t, "The steam target temperature it wants to reach.", families["mara_x_steam_target_temperature_celsius"].GetHelp())
+}
+
+func TestHeatUpDurationPerCycle(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,1500,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0000,1\r\n",
+		"C1.23,068,120,054,0900,1\r\n",
+		"C1.23,068,120,054,0000,0\r\n",
+		"C1.23,068,120,054,1200,1\r\n",
+		"C1.23,068,120,054,0000,0\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.keepCycles = 2
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	steps := []struct {
+		elapsed  time.Duration
+		expected map[string]float64
+	}{
+		{0, map[string]float64{}},
+		{time.Minute, map[string]float64{}},
+		{time.Minute, map[string]float64{"1": 120}},
+		{time.Minute * 10, map[string]float64{"1": 120}},
+		{time.Minute * 3, map[string]float64{"1": 120, "2": 180}},
+		{time.Minute * 10, map[string]float64{"1": 120, "2": 180}},
+		{time.Minute, map[string]float64{"2": 180, "3": 60}},
+	}
+	for _, step := range steps {
+		clock.add(step.elapsed)
+		durations := map[string]float64{}
+		for _, metric := range gather(t, collector)["mara_x_heat_up_duration_seconds"].GetMetric() {
+			durations[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+		assert.Equal(t, step.expected, durations)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.exposeGoroutines = true
+	collector.exposeState = true
+
+	descs := make(chan *prometheus.Desc, 100)
+	collector.Describe(descs)
+	close(descs)
+	described := map[*prometheus.Desc]bool{}
+	for desc := range descs {
+		described[desc] = true
+	}
+
+	for _, desc := range []*prometheus.Desc{
+		collector.info, collector.steamTemp.desc, collector.steamTargetTemp.desc,
+		collector.hxTemp.desc, collector.readyCountdown, collector.heating,
+	} {
+		assert.True(t, described[desc], desc.String())
+	}
+
+	metrics := make(chan prometheus.Metric, 100)
+	collector.Collect(metrics)
+	close(metrics)
+	var collected int
+	for metric := range metrics {
+		collected++
+		assert.True(t, described[metric.Desc()], metric.Desc().String())
+	}
+	assert.NotZero(t, collected)
+}
+
+func TestMachinePoweredGrace(t *testing.T) {
+	line := "C1.23,068,120,054,0820,1\r\n"
+	port := &fakePort{lines: []string{line}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.poweredGrace = time.Second * 30
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_machine_powered"))
+
+	// a brief silence does not flip the gauge
+	clock.add(time.Second * 10)
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_machine_powered"))
+
+	port.lines = []string{line}
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_machine_powered"))
+
+	// a sustained one does
+	clock.add(time.Second * 20)
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_machine_powered"))
+	clock.add(time.Second * 20)
+	assert.Equal(t, float64(0), gaugeValue(t, gather(t, collector), "mara_x_machine_powered"))
+
+	// and recovery is immediate
+	port.lines = []string{line}
+	assert.Equal(t, float64(1), gaugeValue(t, gather(t, collector), "mara_x_machine_powered"))
+}
+
+func TestScrapeIntervalObserved(t *testing.T) {
+	collector := newCollector(&fakePort{}, serial.OpenOptions{})
+	collector.exposeScrapeInterval = true
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	assert.NotContains(t, gather(t, collector), "mara_x_scrape_interval_observed_seconds")
+
+	clock.add(time.Second * 15)
+	assert.Equal(t, float64(15), gaugeValue(t, gather(t, collector), "mara_x_scrape_interval_observed_seconds"))
+
+	clock.add(time.Millisecond * 2500)
+	assert.Equal(t, 2.5, gaugeValue(t, gather(t, collector), "mara_x_scrape_interval_observed_seconds"))
+}
+
+// linePort returns the same line on every read until it is changed, it is
+// safe for concurrent use.
+type linePort struct {
+	fakePort
+	mu    sync.Mutex
+	line  string
+	reads int
+}
+
+func (p *linePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reads++
+	return copy(b, p.line), nil
+}
+
+func (p *linePort) set(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = line
+}
+
+func (p *linePort) readCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reads
+}
+
+func TestPolling(t *testing.T) {
+	port := &linePort{line: "C1.23,068,120,054,0820,1\r\n"}
+	collector := newCollector(port, serial.OpenOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collector.startPolling(ctx, time.Millisecond*10)
+
+	hxTemp := func() float64 {
+		families := gather(t, collector)
+		if _, ok := families["mara_x_hx_temperature_celsius"]; !ok {
+			return 0
+		}
+		return gaugeValue(t, families, "mara_x_hx_temperature_celsius")
+	}
+	waitFor(t, func() bool { return hxTemp() == 54 })
+
+	port.set("C1.23,068,120,060,0820,1\r\n")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				temp := hxTemp()
+				assert.True(t, temp == 54 || temp == 60, "unexpected temperature %v", temp)
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, func() bool { return hxTemp() == 60 })
+
+	// scrapes do not read from the serial port once polling stopped
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	reads := port.readCount()
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, float64(60), hxTemp())
+	}
+	assert.Equal(t, reads, port.readCount())
+}
+
+// errPort fails every read with err.
+type errPort struct {
+	fakePort
+	err error
+}
+
+func (p *errPort) Read(b []byte) (int, error) {
+	return 0, p.err
+}
+
+func TestReadErrors(t *testing.T) {
+	port := &fakePort{lines: []string{"garbage\r\n", "garbage\r\n", "garbage\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.open = func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return &emptyPort{}, nil
+	}
+	readErrors := func() map[string]float64 {
+		values := map[string]float64{}
+		for _, metric := range gather(t, collector)["mara_x_read_errors_total"].GetMetric() {
+			values[metric.GetLabel()[0].GetValue()] = metric.GetCounter().GetValue()
+		}
+		return values
+	}
+
+	assert.Equal(t, map[string]float64{"timeout": 0, "parse": 1, "io": 0}, readErrors())
+
+	collector.serialPort = &errPort{err: errors.New("device gone")}
+	assert.Equal(t, map[string]float64{"timeout": 0, "parse": 1, "io": 1}, readErrors())
+
+	collector.serialPort = &emptyPort{}
+	collector.nonBlocking = true
+	collector.readTimeout = time.Millisecond
+	assert.Equal(t, map[string]float64{"timeout": 1, "parse": 1, "io": 1}, readErrors())
+}
+
+func TestUnexpectedVersionChange(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.24,068,120,054,0810,1\r\n",
+		"C1.25,068,120,054,1500,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	assert.Equal(t, float64(0), counterValue(t, gather(t, collector), "mara_x_unexpected_version_change_total"))
+	assert.Equal(t, float64(1), counterValue(t, gather(t, collector), "mara_x_unexpected_version_change_total"))
+	assert.Contains(t, logs.String(), "WARN firmware version changed without a reboot, check for cross-talk or a wrong serial device from=1.23 to=1.24")
+
+	// a version change with the countdown restarting is a reboot
+	logs.Reset()
+	assert.Equal(t, float64(1), counterValue(t, gather(t, collector), "mara_x_unexpected_version_change_total"))
+	assert.NotContains(t, logs.String(), "WARN")
+}
+
+func TestCountdownThresholds(t *testing.T) {
+	port := &fakePort{}
+	for _, countdown := range []int{900, 500, 0, 700, 900} {
+		port.lines = append(port.lines, fmt.Sprintf("C1.23,068,120,054,%04d,1\r\n", countdown))
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.countdownMax = 1000
+	collector.rebootThreshold = 800
+
+	steps := []struct {
+		progress float64
+		reboots  float64
+	}{
+		{0.1, 0},
+		{0.5, 0},
+		{1, 0},
+		// the countdown rising below the threshold is not a reboot
+		{0.3, 0},
+		{0.1, 1},
+	}
+	for _, step := range steps {
+		families := gather(t, collector)
+		assert.InDelta(t, step.progress, gaugeValue(t, families, "mara_x_fast_heating_progress_ratio"), 0.0001)
+		assert.Equal(t, step.reboots, counterValue(t, families, "mara_x_reboots_total"))
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	open := func(lines ...string) opener {
+		return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+			return &fakePort{lines: lines}, nil
+		}
+	}
+
+	collector, err := newMaraXCollector(open("C1.2\r\n", "\x00\xff\r\n", "C1.23,068,120,054,0820,1\r\n", "C1.23,068,120,055,0820,1\r\n"), *serialDevice)
+	require.NoError(t, err)
+	// the status read during warm-up is kept
+	require.NotNil(t, collector.previous)
+	assert.Equal(t, uint16(54), collector.previous.hxTemp)
+	status, err := collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(55), status.hxTemp)
+
+	garbled := open("0820,1\r\n", "C1.2\r\n", "\x00\xff\r\n", "garbage\r\n", "C1.23,068,120,054,0820,1\r\n")
+	_, err = newMaraXCollector(garbled, *serialDevice)
+	assert.Error(t, err)
+
+	// unless it is not required, a failed warm-up is only logged
+	*warmupRequired = false
+	defer func() { *warmupRequired = true }()
+	collector, err = newMaraXCollector(garbled, *serialDevice)
+	require.NoError(t, err)
+	assert.Nil(t, collector.previous)
+	status, err = collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(54), status.hxTemp)
+
+	// stdin is not warmed up, its first line is not lost
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = strings.NewReader("C1.23,068,120,054,0820,1\r\nC1.23,068,120,056,0820,1\r\n")
+	collector, err = newMaraXCollector(open(), stdinDevice)
+	require.NoError(t, err)
+	assert.Nil(t, collector.previous)
+	status, err = collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(54), status.hxTemp)
+
+	*warmupReads = 0
+	defer func() { *warmupReads = 3 }()
+	collector, err = newMaraXCollector(open("0820,1\r\n", "C1.23,068,120,054,0820,1\r\n"), *serialDevice)
+	require.NoError(t, err)
+	status, err = collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(54), status.hxTemp)
+}
+
+// exclusivePort records whether it was read from concurrently.
+type exclusivePort struct {
+	fakePort
+	reading    int32
+	concurrent int32
+	reads      int32
+}
+
+func (p *exclusivePort) Read(b []byte) (int, error) {
+	if atomic.AddInt32(&p.reading, 1) > 1 {
+		atomic.StoreInt32(&p.concurrent, 1)
+	}
+	defer atomic.AddInt32(&p.reading, -1)
+	atomic.AddInt32(&p.reads, 1)
+
+	time.Sleep(time.Millisecond * 5)
+	return copy(b, "C1.23,068,120,054,0820,1\r\n"), nil
+}
+
+func TestConcurrentCollect(t *testing.T) {
+	port := &exclusivePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			families := gather(t, collector)
+			assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(10), atomic.LoadInt32(&port.reads))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&port.concurrent), "the serial port was read concurrently")
+}
+
+func TestSkipOverlappingScrapes(t *testing.T) {
+	port := &blockPort{
+		fakePort: fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}},
+		started:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.skipOverlapping = true
+
+	done := make(chan map[string]*dto.MetricFamily)
+	go func() { done <- gather(t, collector) }()
+	<-port.started
+
+	// the slow read is still in progress, so this scrape does not wait for it
+	families := gather(t, collector)
+	assert.Equal(t, float64(1), counterValue(t, families, "mara_x_scrape_skipped_total"))
+	assert.NotContains(t, families, "mara_x_hx_temperature_celsius")
+
+	close(port.release)
+	families = <-done
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(1), counterValue(t, families, "mara_x_scrape_skipped_total"))
+}
+
+func TestSerialReconnect(t *testing.T) {
+	collector := newCollector(&errPort{err: errors.New("device gone")}, serial.OpenOptions{})
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+	var opens int
+	collector.open = func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		opens++
+		if opens < 3 {
+			return nil, errors.New("no such device")
+		}
+		return &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, nil
+	}
+	connected := func() float64 {
+		return gaugeValue(t, gather(t, collector), "mara_x_serial_connected")
+	}
+
+	assert.Equal(t, float64(1), connected())
+	assert.Equal(t, float64(1), connected())
+	assert.Equal(t, float64(0), connected())
+	assert.Equal(t, 0, opens)
+
+	// the first attempt is immediate, then the backoff doubles
+	assert.Equal(t, float64(0), connected())
+	assert.Equal(t, 1, opens)
+	assert.Equal(t, float64(0), connected())
+	assert.Equal(t, 1, opens)
+
+	clock.add(time.Second)
+	assert.Equal(t, float64(0), connected())
+	assert.Equal(t, 2, opens)
+
+	clock.add(time.Second)
+	assert.Equal(t, float64(0), connected())
+	assert.Equal(t, 2, opens)
+
+	clock.add(time.Second)
+	families := gather(t, collector)
+	assert.Equal(t, 3, opens)
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_serial_connected"))
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+}
+
+func TestSerialReconnectMinInterval(t *testing.T) {
+	collector := newCollector(&errPort{err: errors.New("device gone")}, serial.OpenOptions{})
+	collector.reconnectMinInterval = time.Second * 10
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+	var opens int
+	collector.open = func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		opens++
+		return &errPort{err: errors.New("device gone")}, nil
+	}
+
+	// every open succeeds but the port disconnects again right away, without
+	// the limit it would be reopened after every third scrape.
+	for i := 0; i < 20; i++ {
+		gather(t, collector)
+		clock.add(time.Millisecond * 100)
+	}
+	assert.Equal(t, 1, opens)
+
+	// the only attempt was on the fourth scrape
+	clock.add(time.Second*8 + time.Millisecond*200)
+	gather(t, collector)
+	assert.Equal(t, 1, opens)
+	clock.add(time.Millisecond * 100)
+	gather(t, collector)
+	assert.Equal(t, 2, opens)
+}
+
+func TestHeatingOnDurationHistogram(t *testing.T) {
+	port := &fakePort{lines: []string{
+		"C1.23,068,120,054,0820,0\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+		"C1.23,068,120,054,0820,0\r\n",
+		"C1.23,068,120,054,0820,1\r\n",
+	}}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.heatingHistogram = true
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	collector.now = clock.now
+
+	var families map[string]*dto.MetricFamily
+	for _, elapsed := range []time.Duration{0, time.Second, time.Second * 20, time.Second * 25, time.Second * 5} {
+		clock.add(elapsed)
+		families = gather(t, collector)
+	}
+
+	// the period still in progress is not observed yet
+	histogram := families["mara_x_heating_on_duration_seconds"].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(1), histogram.GetSampleCount())
+	assert.Equal(t, float64(45), histogram.GetSampleSum())
+}
+
+// closePort records whether it was closed.
+type closePort struct {
+	fakePort
+	closed bool
+}
+
+func (p *closePort) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestRunShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	port := &closePort{}
+	collector := newCollector(port, serial.OpenOptions{})
+	served := make(chan error, 1)
+	serve := func(server *http.Server) error {
+		err := server.Serve(listener)
+		served <- err
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		run(ctx, []*maraXCollector{collector}, http.DefaultClient, serve)
+		close(done)
+	}()
+
+	waitFor(t, func() bool {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+	assert.False(t, port.closed)
+
+	cancel()
+	<-done
+	assert.Equal(t, http.ErrServerClosed, <-served)
+	assert.True(t, port.closed)
+}
+
+func TestModeAndHeatingTransitions(t *testing.T) {
+	port := &fakePort{}
+	for _, line := range []string{
+		"C1.23,068,120,054,0000,1",
+		"C1.23,068,120,054,0000,0",
+		"V1.23,068,120,054,0000,0",
+		"V1.23,068,120,054,0000,1",
+		"V1.23,068,120,054,0000,1",
+		"C1.23,068,120,054,0000,0",
+	} {
+		port.lines = append(port.lines, line+"\r\n")
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+
+	steps := []struct {
+		modeChanges    float64
+		heatingToggles float64
+	}{
+		// the first reading has nothing to compare to
+		{0, 0},
+		{0, 1},
+		{1, 1},
+		{1, 2},
+		{1, 2},
+		{2, 3},
+	}
+	for _, step := range steps {
+		families := gather(t, collector)
+		assert.Equal(t, step.modeChanges, counterValue(t, families, "mara_x_mode_changes_total"))
+		assert.Equal(t, step.heatingToggles, counterValue(t, families, "mara_x_heating_toggles_total"))
+	}
+}
+
+func TestModeChangesDebounced(t *testing.T) {
+	port := &fakePort{}
+	for _, line := range []string{
+		"C1.23,068,120,054,0000,1",
+		"V1.23,068,120,054,0000,1",
+		"C1.23,068,120,054,0000,1",
+		"C1.23,068,120,054,0000,1",
+		"V1.23,068,120,054,0000,1",
+		"V1.23,068,120,054,0000,1",
+		"V1.23,068,120,054,0000,1",
+	} {
+		port.lines = append(port.lines, line+"\r\n")
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.modeDebounce = 3
+
+	// a single flapping line does not change the mode, only three in a row do
+	for _, modeChanges := range []float64{0, 0, 0, 0, 0, 0, 1} {
+		families := gather(t, collector)
+		assert.Equal(t, modeChanges, counterValue(t, families, "mara_x_mode_changes_total"))
+	}
+}
+
+func TestTemperatureSmoothing(t *testing.T) {
+	port := &fakePort{}
+	for _, hx := range []int{90, 100, 90, 100, 100, 100} {
+		port.lines = append(port.lines, fmt.Sprintf("C1.23,120,120,%03d,0000,1\r\n", hx))
+	}
+	collector := newCollector(port, serial.OpenOptions{})
+	collector.smoothing = true
+	collector.smoothSteam.alpha = 0.5
+	collector.smoothHx.alpha = 0.5
+
+	raw := []float64{90, 100, 90, 100, 100, 100}
+	smoothed := []float64{90, 95, 92.5, 96.25, 98.125, 99.0625}
+	for i := range raw {
+		families := gather(t, collector)
+		assert.Equal(t, raw[i], gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+		assert.InDelta(t, smoothed[i], gaugeValue(t, families, "mara_x_hx_temperature_smoothed_celsius"), 0.0001)
+		assert.Equal(t, float64(120), gaugeValue(t, families, "mara_x_steam_temperature_smoothed_celsius"))
+	}
+
+	collector = newCollector(&fakePort{lines: []string{"C1.23,120,120,090,0000,1\r\n"}}, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_hx_temperature_smoothed_celsius")
+}
+
+func TestLegacyMetricNames(t *testing.T) {
+	lines := []string{"C1.23,068,120,054,0820,1\r\n"}
+	families := gather(t, newCollector(&fakePort{lines: lines}, serial.OpenOptions{}))
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.NotContains(t, families, "mara_x_hx_temperature")
+	assert.NotContains(t, families, "mara_x_steam_temperature")
+
+	*legacyMetricNames = true
+	defer func() { *legacyMetricNames = false }()
+	lines = []string{"C1.23,068,120,054,0820,1\r\n"}
+	families = gather(t, newCollector(&fakePort{lines: lines}, serial.OpenOptions{}))
+	for _, name := range []string{"steam_temperature", "steam_target_temperature", "hx_temperature"} {
+		assert.Equal(t, gaugeValue(t, families, "mara_x_"+name+"_celsius"), gaugeValue(t, families, "mara_x_"+name), name)
+	}
+	assert.Contains(t, families["mara_x_hx_temperature"].GetHelp(), "Deprecated, use mara_x_hx_temperature_celsius.")
+}
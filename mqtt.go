@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// mqttPublishTimeout is how long publishing a single message may take.
+	mqttPublishTimeout = time.Second * 5
+	// mqttQueueSize is the number of statuses waiting to be published.
+	mqttQueueSize = 16
+)
+
+// mqttClient publishes a payload to a topic of an MQTT broker.
+type mqttClient interface {
+	Publish(topic, payload string) error
+}
+
+// mqttMessage is a single field of a status published to MQTT.
+type mqttMessage struct {
+	topic   string
+	payload string
+}
+
+// mqttPublisher publishes each field of every status to its own topic below
+// the prefix, for example maraX/steamTemp. The statuses are published in the
+// background so a slow or unreachable broker does not block scrapes.
+type mqttPublisher struct {
+	client mqttClient
+	prefix string
+	queue  chan *maraXStatus
+}
+
+func newMQTTPublisher(client mqttClient, prefix string) *mqttPublisher {
+	p := &mqttPublisher{client: client, prefix: prefix, queue: make(chan *maraXStatus, mqttQueueSize)}
+	go p.run()
+	return p
+}
+
+// Emit queues the status to be published. If the queue is full, for example
+// during a broker outage, the oldest status is dropped to make room.
+func (p *mqttPublisher) Emit(status *maraXStatus) {
+	for {
+		select {
+		case p.queue <- status:
+			return
+		default:
+		}
+		select {
+		case <-p.queue:
+			slog.Warn("MQTT publish queue is full, dropping the oldest status")
+		default:
+		}
+	}
+}
+
+// run publishes the fields of the queued statuses. Errors are only logged so
+// that a broker outage does not affect the metrics.
+func (p *mqttPublisher) run() {
+	for status := range p.queue {
+		for _, message := range mqttMessages(p.prefix, status) {
+			if err := p.client.Publish(message.topic, message.payload); err != nil {
+				slog.Warn("error publishing to MQTT", "topic", message.topic, "err", err)
+			}
+		}
+	}
+}
+
+// mqttMessages serializes the status into one message per field. The
+// optional fields are left out if the firmware did not report them.
+func mqttMessages(prefix string, status *maraXStatus) []mqttMessage {
+	format := func(v uint16) string { return strconv.FormatUint(uint64(v), 10) }
+	fields := []mqttMessage{
+		{"mode", string(status.mode)},
+		{"version", status.version},
+		{"steamTemp", format(status.steamTemp)},
+		{"steamTargetTemp", format(status.steamTargetTemp)},
+		{"hxTemp", format(status.hxTemp)},
+		{"readyCountdown", format(status.readyCountdown)},
+		{"heating", strconv.FormatBool(status.heating)},
+	}
+	if status.setTemp != nil {
+		fields = append(fields, mqttMessage{"setTemp", format(*status.setTemp)})
+	}
+	if status.pressure != nil {
+		fields = append(fields, mqttMessage{"pressure", strconv.FormatFloat(*status.pressure, 'f', -1, 64)})
+	}
+
+	for i := range fields {
+		fields[i].topic = prefix + "/" + fields[i].topic
+	}
+	return fields
+}
+
+// pahoClient is an mqttClient publishing with QoS 0 over a paho connection.
+type pahoClient struct {
+	client mqtt.Client
+}
+
+// connectMQTT connects to the broker, reconnecting automatically if the
+// connection is lost later on.
+func connectMQTT(broker string) (*pahoClient, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("mara-xporter").
+		SetAutoReconnect(true)
+	client := mqtt.NewClient(opts)
+
+	token := client.Connect()
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("unable to connect to MQTT broker %s: %w", broker, err)
+	}
+	return &pahoClient{client: client}, nil
+}
+
+func (c *pahoClient) Publish(topic, payload string) error {
+	token := c.client.Publish(topic, 0, false, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return errors.New("timed out publishing")
+	}
+	return token.Error()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMQTTClient struct {
+	mu       sync.Mutex
+	messages []mqttMessage
+	err      error
+	// release blocks every publish until it is closed, if set.
+	release chan struct{}
+}
+
+func (c *fakeMQTTClient) Publish(topic, payload string) error {
+	if c.release != nil {
+		<-c.release
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, mqttMessage{topic, payload})
+	return c.err
+}
+
+func (c *fakeMQTTClient) published() []mqttMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]mqttMessage(nil), c.messages...)
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	client := &fakeMQTTClient{}
+	setTemp := uint16(93)
+	newMQTTPublisher(client, "maraX").Emit(&maraXStatus{
+		mode:            steam,
+		version:         "1.23",
+		steamTemp:       116,
+		steamTargetTemp: 120,
+		hxTemp:          95,
+		readyCountdown:  0,
+		heating:         true,
+		setTemp:         &setTemp,
+	})
+
+	waitFor(t, func() bool { return len(client.published()) == 8 })
+	assert.Equal(t, []mqttMessage{
+		{"maraX/mode", "steam"},
+		{"maraX/version", "1.23"},
+		{"maraX/steamTemp", "116"},
+		{"maraX/steamTargetTemp", "120"},
+		{"maraX/hxTemp", "95"},
+		{"maraX/readyCountdown", "0"},
+		{"maraX/heating", "true"},
+		{"maraX/setTemp", "93"},
+	}, client.published())
+}
+
+func TestMQTTPublisherError(t *testing.T) {
+	client := &fakeMQTTClient{err: errors.New("broker gone")}
+	newMQTTPublisher(client, "maraX").Emit(&maraXStatus{mode: coffee})
+	waitFor(t, func() bool { return len(client.published()) == 7 })
+}
+
+func TestMQTTPublisherBlocked(t *testing.T) {
+	client := &fakeMQTTClient{release: make(chan struct{})}
+	publisher := newMQTTPublisher(client, "maraX")
+
+	// a broker which does not respond neither blocks emitting nor lets the
+	// queue grow, the oldest statuses are dropped
+	start := time.Now()
+	for i := 0; i < mqttQueueSize*2; i++ {
+		publisher.Emit(&maraXStatus{mode: coffee, hxTemp: uint16(i)})
+	}
+	assert.True(t, time.Since(start) < time.Second, "emitting took %s", time.Since(start))
+	assert.Len(t, publisher.queue, mqttQueueSize)
+
+	close(client.release)
+	contains := func(message mqttMessage) bool {
+		for _, published := range client.published() {
+			if published == message {
+				return true
+			}
+		}
+		return false
+	}
+	waitFor(t, func() bool { return contains(mqttMessage{"maraX/hxTemp", "31"}) })
+	assert.False(t, contains(mqttMessage{"maraX/hxTemp", "15"}))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ntpPacketSize = 48
+	// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+	// and the unix epoch (1970).
+	ntpEpochOffset = 2208988800
+)
+
+// clockChecker periodically queries an NTP server and exposes the offset of
+// the local clock, as time based metrics are wrong on a drifting clock.
+type clockChecker struct {
+	server    string
+	timeout   time.Duration
+	maxOffset time.Duration
+
+	offsetDesc *prometheus.Desc
+
+	mu     sync.Mutex
+	offset *time.Duration
+}
+
+func newClockChecker(server string, maxOffset time.Duration) *clockChecker {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	return &clockChecker{
+		server:    server,
+		timeout:   time.Second * 5,
+		maxOffset: maxOffset,
+		offsetDesc: prometheus.NewDesc(
+			metricName("exporter", "clock_offset_seconds"),
+			"Offset of the local clock compared to the NTP server.",
+			nil, nil,
+		),
+	}
+}
+
+func (checker *clockChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- checker.offsetDesc
+}
+
+func (checker *clockChecker) Collect(ch chan<- prometheus.Metric) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	// there is nothing to report until the first successful check
+	if checker.offset == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(checker.offsetDesc, prometheus.GaugeValue, checker.offset.Seconds())
+}
+
+// run checks the clock immediately and then on every interval until ctx is
+// done.
+func (checker *clockChecker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checker.check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check queries the NTP server once and updates the offset.
+func (checker *clockChecker) check() {
+	offset, err := queryNTP(checker.server, checker.timeout)
+	if err != nil {
+		slog.Warn("error checking clock against ntp server", "server", checker.server, "err", err)
+		return
+	}
+
+	if time.Duration(math.Abs(float64(offset))) > checker.maxOffset {
+		slog.Warn("local clock is off compared to ntp server, time based metrics may be wrong", "offset", offset, "server", checker.server)
+	}
+
+	checker.mu.Lock()
+	checker.offset = &offset
+	checker.mu.Unlock()
+}
+
+// queryNTP sends a single SNTP request to the server and returns the offset
+// of the local clock to the server's clock.
+func queryNTP(server string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, ntpPacketSize)
+	// leap indicator 0, version 3, mode 3 (client)
+	req[0] = 0x1b
+
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, ntpPacketSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+
+	if n < ntpPacketSize {
+		return 0, fmt.Errorf("short ntp response of %d bytes", n)
+	}
+
+	serverReceived := ntpTime(resp[32:40])
+	serverSent := ntpTime(resp[40:48])
+
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// ntpTime converts a 64 bit NTP timestamp to a time.
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds, (fraction*1e9)>>32)
+}
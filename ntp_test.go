@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putNTPTime writes t as a 64 bit NTP timestamp to b.
+func putNTPTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:8], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
+
+// serveNTP answers a single NTP request on conn with a clock that is offset
+// from the local clock.
+func serveNTP(t *testing.T, conn net.PacketConn, offset time.Duration) {
+	req := make([]byte, ntpPacketSize)
+	_, addr, err := conn.ReadFrom(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	resp := make([]byte, ntpPacketSize)
+	resp[0] = 0x1c
+	now := time.Now().Add(offset)
+	putNTPTime(resp[32:40], now)
+	putNTPTime(resp[40:48], now)
+	_, err = conn.WriteTo(resp, addr)
+	assert.NoError(t, err)
+}
+
+func TestClockChecker(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	go serveNTP(t, conn, time.Second*5)
+
+	checker := newClockChecker(conn.LocalAddr().String(), time.Second)
+	assert.NotContains(t, gather(t, checker), "mara_x_clock_offset_seconds")
+
+	checker.check()
+	offset := gaugeValue(t, gather(t, checker), "mara_x_clock_offset_seconds")
+	assert.InDelta(t, 5, offset, 0.1)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrapeOnce gathers the metrics of gatherer once and writes them to w in the
+// text exposition format for -once. Gathering reads a status from the serial
+// port of each of the collectors, it fails if any of these reads failed.
+func scrapeOnce(w io.Writer, gatherer prometheus.Gatherer, collectors []*maraXCollector) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+
+	for _, collector := range collectors {
+		collector.mu.Lock()
+		failed := collector.lastReadFailed
+		collector.mu.Unlock()
+		if failed {
+			return fmt.Errorf("unable to read from serial device at %s", collector.serialOpts.PortName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeOnce(t *testing.T) {
+	collector := newCollector(&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, serial.OpenOptions{})
+	reg := prometheus.NewRegistry()
+	registerCollectors(reg, []*maraXCollector{collector})
+
+	var out bytes.Buffer
+	require.NoError(t, scrapeOnce(&out, reg, []*maraXCollector{collector}))
+	assert.Contains(t, out.String(), "# TYPE mara_x_hx_temperature_celsius gauge\n")
+	assert.Contains(t, out.String(), "mara_x_hx_temperature_celsius 54\n")
+	assert.Contains(t, out.String(), "mara_x_steam_temperature_celsius 68\n")
+}
+
+func TestScrapeOnceReadFailure(t *testing.T) {
+	collector := newCollector(&emptyPort{}, serial.OpenOptions{PortName: "/dev/ttyUSB0"})
+	reg := prometheus.NewRegistry()
+	registerCollectors(reg, []*maraXCollector{collector})
+
+	var out bytes.Buffer
+	err := scrapeOnce(&out, reg, []*maraXCollector{collector})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/dev/ttyUSB0")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// pollableFile is a serial device file registered with the runtime poller.
+// Unlike the blocking file returned by serial.Open, its read deadlines work
+// and closing it ends a read blocked on it.
+type pollableFile struct {
+	*os.File
+}
+
+// pollableOpener returns an opener which makes the files opened by open
+// pollable. A file which cannot be made pollable is returned as is and read
+// with the goroutine based line reader.
+func pollableOpener(open opener) opener {
+	return func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		port, err := open(options)
+		if err != nil {
+			return nil, err
+		}
+		file, ok := port.(*os.File)
+		if !ok {
+			return port, nil
+		}
+		pollable, err := newPollableFile(file)
+		if err != nil {
+			if !errors.Is(err, errors.ErrUnsupported) {
+				slog.Warn("unable to make serial device pollable, a timed out read may block reopening it", "device", options.PortName, "err", err)
+			}
+			return file, nil
+		}
+		return pollable, nil
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// newPollableFile returns a pollable duplicate of file and closes file.
+// serial.Open clears the non-blocking flag after opening the device, which
+// leaves the file registered with the runtime poller but reading in read(2)
+// directly.
+func newPollableFile(file *os.File) (*pollableFile, error) {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fd := -1
+	var dupErr error
+	if err := conn.Control(func(f uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		fd, dupErr = syscall.Dup(int(f))
+		if dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+	// the duplicate shares the flags of file, os.NewFile only registers
+	// descriptors which are non-blocking already with the poller
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	pollable := &pollableFile{File: os.NewFile(uintptr(fd), file.Name())}
+	_ = file.Close()
+	return pollable, nil
+}
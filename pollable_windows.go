@@ -0,0 +1,12 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// newPollableFile is not supported on windows, the file is read with the
+// goroutine based line reader.
+func newPollableFile(file *os.File) (*pollableFile, error) {
+	return nil, errors.ErrUnsupported
+}
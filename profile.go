@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts writing a CPU profile to cpuPath and returns a
+// function which stops it and writes a heap profile to memPath. Either
+// profile is disabled if its path is empty.
+func startProfiling(cpuPath, memPath string) (func() error, error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		var err error
+		cpuFile, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("unable to start CPU profile: %w", err)
+		}
+	}
+
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				return fmt.Errorf("unable to write CPU profile: %w", err)
+			}
+		}
+		if memPath == "" {
+			return nil
+		}
+
+		memFile, err := os.Create(memPath)
+		if err != nil {
+			return fmt.Errorf("unable to create memory profile: %w", err)
+		}
+		defer memFile.Close()
+		// get up-to-date statistics of the allocations
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(memFile); err != nil {
+			return fmt.Errorf("unable to write memory profile: %w", err)
+		}
+		return memFile.Close()
+	}, nil
+}
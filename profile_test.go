@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartProfiling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cpuPath := filepath.Join(dir, "cpu.pprof")
+	memPath := filepath.Join(dir, "mem.pprof")
+	stop, err := startProfiling(cpuPath, memPath)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		_, err := parseLine([]byte("C1.23,068,120,054,0820,1\r\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, stop())
+
+	for _, path := range []string{cpuPath, memPath} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.NotZero(t, info.Size(), path)
+	}
+}
+
+func TestStartProfilingDisabled(t *testing.T) {
+	stop, err := startProfiling("", "")
+	require.NoError(t, err)
+	assert.NoError(t, stop())
+}
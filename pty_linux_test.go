@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openPty opens a pseudo terminal and returns its master and the path of its
+// slave device.
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("unable to open pty: %s", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skipf("unable to unlock pty: %s", errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Skipf("unable to get pty number: %s", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestReadLineTimeoutSerialDevice(t *testing.T) {
+	master, slave := openPty(t)
+
+	options := serial.OpenOptions{
+		PortName:        slave,
+		BaudRate:        9600,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+	port, err := serial.Open(options)
+	require.NoError(t, err)
+	defer func() {
+		// closing the master ends the read still blocked on the slave,
+		// which closing the slave would otherwise wait for
+		master.Close()
+		port.Close()
+	}()
+
+	collector := newCollector(port, options)
+	collector.readTimeout = 500 * time.Millisecond
+
+	start := time.Now()
+	_, err = collector.readLine(context.Background())
+	assert.True(t, errors.Is(err, errReadTimeout), "unexpected error: %v", err)
+	assert.True(t, time.Since(start) < 2*time.Second, "read took %s", time.Since(start))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	collector.readTimeout = time.Minute
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = collector.readLine(ctx)
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	assert.True(t, time.Since(start) < 2*time.Second, "read took %s", time.Since(start))
+
+	_, err = master.Write([]byte("C1.23,068,120,054,0820,1\r\n"))
+	require.NoError(t, err)
+	line, err := collector.readLine(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "C1.23,068,120,054,0820,1\r\n", string(line))
+}
+
+func TestReadSerialLineReopenSerialDevice(t *testing.T) {
+	*readTimeout = time.Millisecond * 100
+	*warmupReads = 0
+	defer func() {
+		*readTimeout = defaultReadTimeout
+		*warmupReads = 3
+	}()
+	master, slave := openPty(t)
+	defer master.Close()
+
+	var opened int
+	collector, err := newMaraXCollector(func(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+		opened++
+		return serial.Open(options)
+	}, slave)
+	require.NoError(t, err)
+	defer func() { collector.serialPort.Close() }()
+	_, ok := collector.serialPort.(*pollableFile)
+	require.True(t, ok, "serial device is not pollable")
+
+	// the timed out read neither blocks closing the port nor reading the
+	// reopened one while the machine stays silent
+	done := make(chan error, 1)
+	go func() {
+		_, err := collector.readSerialLine(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, errReadTimeout), "unexpected error: %v", err)
+	case <-time.After(time.Second * 2):
+		t.Fatal("read did not time out")
+	}
+	assert.Equal(t, 2, opened)
+
+	// the first line after reopening is dropped as it may be partial
+	_, err = master.Write([]byte("C1.23,068,120,054,0820,1\r\nC1.23,068,120,055,0820,1\r\n"))
+	require.NoError(t, err)
+	line, err := collector.readSerialLine(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "C1.23,068,120,055,0820,1\r\n", string(line))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+// newPusher returns a pusher for the metrics of the collector to the
+// Pushgateway at url. The metrics are grouped by job and the hostname as
+// instance. All requests are sent with client.
+func newPusher(url, job string, collector prometheus.Collector, client *http.Client) *push.Pusher {
+	instance, err := os.Hostname()
+	if err != nil {
+		slog.Warn("unable to get hostname for push instance", "err", err)
+		instance = "unknown"
+	}
+
+	return push.New(url, job).
+		Client(client).
+		Collector(collector).
+		Grouping("instance", instance).
+		Format(expfmt.FmtText)
+}
+
+// runPusher pushes the metrics immediately and then on every interval until
+// ctx is done. On return the pushed metrics are deleted from the Pushgateway
+// again so no stale data is left behind.
+func runPusher(ctx context.Context, pusher *push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := pusher.Push(); err != nil {
+			slog.Warn("error pushing metrics to pushgateway", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := pusher.Delete(); err != nil {
+				slog.Warn("error deleting metrics from pushgateway", "err", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunPusher(t *testing.T) {
+	type request struct {
+		method string
+		path   string
+		body   string
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{method: r.Method, path: r.URL.Path, body: string(body)}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	pusher := newPusher(server.URL, "mara-x", newCollector(port, serial.OpenOptions{}), http.DefaultClient)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runPusher(ctx, pusher, time.Hour)
+		close(done)
+	}()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	path := "/metrics/job/mara-x/instance/" + hostname
+
+	pushed := <-requests
+	assert.Equal(t, http.MethodPut, pushed.method)
+	assert.Equal(t, path, pushed.path)
+	assert.Contains(t, pushed.body, "mara_x_hx_temperature_celsius 54")
+
+	cancel()
+	<-done
+
+	deleted := <-requests
+	assert.Equal(t, http.MethodDelete, deleted.method)
+	assert.Equal(t, path, deleted.path)
+}
+
+func TestRunNoHTTP(t *testing.T) {
+	pushed := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed <- r.Method
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	defer func(noServer bool, url string) {
+		*noHTTP = noServer
+		*pushgatewayURL = url
+	}(*noHTTP, *pushgatewayURL)
+	*noHTTP = true
+	*pushgatewayURL = server.URL
+
+	collector := newCollector(&fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}, serial.OpenOptions{})
+	served := make(chan struct{}, 1)
+	serve := func(*http.Server) error {
+		served <- struct{}{}
+		select {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		run(ctx, []*maraXCollector{collector}, http.DefaultClient, serve)
+		close(done)
+	}()
+
+	assert.Equal(t, http.MethodPut, <-pushed)
+	cancel()
+	<-done
+	assert.Equal(t, http.MethodDelete, <-pushed)
+
+	select {
+	case <-served:
+		t.Fatal("HTTP server started despite -no-http")
+	default:
+	}
+}
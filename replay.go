@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// replayPort serves the lines of a file in place of a serial port, starting
+// over once all of them have been read. A read returns at most a single
+// line so each read from the collector consumes one.
+type replayPort struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	// pending is what remains of the current line after a short read.
+	pending []byte
+}
+
+// openReplay reads the lines of the file at path. The line endings are
+// normalized to CRLF as sent by the machine and empty lines are skipped.
+func openReplay(path string) (*replayPort, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read replay file: %w", err)
+	}
+
+	port := &replayPort{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+		port.lines = append(port.lines, append(line, '\r', '\n'))
+	}
+	if len(port.lines) == 0 {
+		return nil, fmt.Errorf("replay file %s does not contain any lines", path)
+	}
+	return port, nil
+}
+
+// replayOpener returns an opener which opens the file at path for replay
+// regardless of the serial options.
+func replayOpener(path string) opener {
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return openReplay(path)
+	}
+}
+
+func (p *replayPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) == 0 {
+		p.pending = p.lines[p.next]
+		p.next = (p.next + 1) % len(p.lines)
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *replayPort) Write(b []byte) (int, error) {
+	return 0, errors.New("replay file is read-only")
+}
+
+func (p *replayPort) Close() error {
+	return nil
+}
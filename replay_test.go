@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayCycles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("C1.23,068,120,054,0820,1\nV1.23,116,120,095,0000,0\n"), 0o644))
+
+	port, err := replayOpener(path)(serial.OpenOptions{})
+	require.NoError(t, err)
+	collector := newCollector(port, serial.OpenOptions{})
+
+	for _, expected := range []float64{54, 95, 54, 95} {
+		assert.Equal(t, expected, gaugeValue(t, gather(t, collector), "mara_x_hx_temperature_celsius"))
+	}
+}
+
+func TestReplaySample(t *testing.T) {
+	port, err := openReplay(filepath.Join("testdata", "replay.txt"))
+	require.NoError(t, err)
+
+	for _, line := range port.lines {
+		_, err := parseLine(line)
+		assert.NoError(t, err, string(line))
+	}
+}
+
+func TestReplayEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("\n\n"), 0o644))
+
+	_, err := openReplay(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sort"
+
+// sensorFields are the fields of a status validated against a range, with a
+// function returning their value.
+var sensorFields = map[string]func(status *maraXStatus) uint16{
+	"steam_temp": func(status *maraXStatus) uint16 { return status.steamTemp },
+	"hx_temp":    func(status *maraXStatus) uint16 { return status.hxTemp },
+}
+
+// validateStatus checks the fields of the status against their ranges and
+// returns for each checked field whether it is out of range, which hints at
+// a sensor glitch. Fields without a range or with an unset one are not
+// checked.
+func validateStatus(status *maraXStatus, ranges map[string]rangeFlag) map[string]bool {
+	faults := make(map[string]bool, len(ranges))
+	for field, r := range ranges {
+		value, ok := sensorFields[field]
+		if !ok || !r.set {
+			continue
+		}
+		faults[field] = !r.contains(value(status))
+	}
+	return faults
+}
+
+// faultyFields returns the sorted fields which are faulty.
+func faultyFields(faults map[string]bool) []string {
+	var fields []string
+	for field, faulty := range faults {
+		if faulty {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,21 @@
+package main
+
+// movingAverage is an exponential moving average, each value is weighted by
+// alpha and the previous average by 1-alpha.
+type movingAverage struct {
+	alpha float64
+	value float64
+	set   bool
+}
+
+// add adds a value and returns the new average, the first value is taken as
+// it is.
+func (m *movingAverage) add(value float64) float64 {
+	if !m.set {
+		m.value = value
+		m.set = true
+		return m.value
+	}
+	m.value = m.alpha*value + (1-m.alpha)*m.value
+	return m.value
+}
@@ -0,0 +1,31 @@
+package main
+
+const (
+	stateOff     = "off"
+	stateHeating = "heating"
+	stateReady   = "ready"
+	stateError   = "error"
+)
+
+// machineStates are all states of the state metric.
+var machineStates = []string{stateOff, stateHeating, stateReady, stateError}
+
+// machineState rolls the status up into a single state:
+//   - error if no status could be read, status is nil then.
+//   - ready if the ready countdown reached 0.
+//   - heating if the machine is still getting ready and the heating element
+//     is on.
+//   - off if the machine is still getting ready but the heating element is
+//     off.
+func machineState(status *maraXStatus) string {
+	switch {
+	case status == nil:
+		return stateError
+	case status.readyCountdown == 0:
+		return stateReady
+	case status.heating:
+		return stateHeating
+	default:
+		return stateOff
+	}
+}
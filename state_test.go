@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMachineState(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   *maraXStatus
+		expected string
+	}{
+		{"unreadable", nil, stateError},
+		{"ready", &maraXStatus{readyCountdown: 0, heating: true}, stateReady},
+		{"ready idle", &maraXStatus{readyCountdown: 0, heating: false}, stateReady},
+		{"heating up", &maraXStatus{readyCountdown: 820, heating: true}, stateHeating},
+		{"not heating", &maraXStatus{readyCountdown: 820, heating: false}, stateOff},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, machineState(test.status))
+		})
+	}
+}
+
+func TestStateMetric(t *testing.T) {
+	port := &fakePort{lines: []string{"C1.23,068,120,054,0820,1\r\n"}}
+	collector := newCollector(port, serial.OpenOptions{})
+	assert.NotContains(t, gather(t, collector), "mara_x_state")
+	collector.exposeState = true
+
+	// the port is exhausted after the first line, so reading fails
+	families := gather(t, collector)
+	require.Contains(t, families, "mara_x_state")
+	metrics := families["mara_x_state"].GetMetric()
+	require.Len(t, metrics, len(machineStates))
+	for _, metric := range metrics {
+		state := metric.GetLabel()[0].GetValue()
+		assert.Equal(t, boolToFloat(state == stateError), metric.GetGauge().GetValue(), state)
+	}
+
+	port.lines = []string{"C1.23,068,120,054,0000,1\r\n"}
+	for _, metric := range gather(t, collector)["mara_x_state"].GetMetric() {
+		state := metric.GetLabel()[0].GetValue()
+		assert.Equal(t, boolToFloat(state == stateReady), metric.GetGauge().GetValue(), state)
+	}
+}
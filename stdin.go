@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// stdinDevice is the -serial-dev reading the lines from stdin instead of a
+// serial device, for example when piping in a capture.
+const stdinDevice = "-"
+
+// stdin is read from by stdinDevice, it can be replaced in tests.
+var stdin io.Reader = os.Stdin
+
+// readerPort is a read-only port reading from a plain reader.
+type readerPort struct {
+	io.Reader
+}
+
+func (p readerPort) Write(b []byte) (int, error) {
+	return 0, errors.New("stdin is read-only")
+}
+
+// Close does nothing, reopening the port after a timeout continues reading
+// from the same reader.
+func (p readerPort) Close() error {
+	return nil
+}
+
+// readerOpener returns an opener which reads from r regardless of the serial
+// options.
+func readerOpener(r io.Reader) opener {
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return readerPort{Reader: r}, nil
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStdinDevice(t *testing.T) {
+	*warmupReads = 0
+	defer func(r io.Reader) {
+		stdin = r
+		*warmupReads = 3
+	}(stdin)
+	stdin = strings.NewReader("C1.23,068,120,054,0820,1\r\nV1.23,070,120,060,0000,0\r\n")
+
+	collector, err := newMaraXCollector(serial.Open, stdinDevice)
+	require.NoError(t, err)
+
+	families := gather(t, collector)
+	assert.Equal(t, float64(54), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(820), gaugeValue(t, families, "mara_x_ready_countdown"))
+
+	families = gather(t, collector)
+	assert.Equal(t, float64(60), gaugeValue(t, families, "mara_x_hx_temperature_celsius"))
+	assert.Equal(t, float64(1), gaugeValue(t, families, "mara_x_mode"))
+
+	_, err = collector.serialPort.Write([]byte("x"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+const (
+	// tcpScheme prefixes a -serial-dev which is a serial bridge like ser2net
+	// reachable over TCP instead of a local serial device.
+	tcpScheme = "tcp://"
+	// tcpDialTimeout is how long connecting to a serial bridge may take.
+	tcpDialTimeout = time.Second * 5
+)
+
+// tcpAddress returns the host:port of a serial bridge device and whether
+// device is one.
+func tcpAddress(device string) (string, bool) {
+	if !strings.HasPrefix(device, tcpScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(device, tcpScheme), true
+}
+
+// tcpOpener returns an opener which connects to the serial bridge at addr
+// regardless of the serial options, those are configured on the bridge.
+func tcpOpener(addr string) opener {
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", addr, tcpDialTimeout)
+	}
+}
+
+// deviceOpener returns the opener for device, which is open making the
+// serial device pollable unless device is a serial bridge or stdin.
+func deviceOpener(open opener, device string) opener {
+	if device == stdinDevice {
+		return readerOpener(stdin)
+	}
+	if addr, ok := tcpAddress(device); ok {
+		return tcpOpener(addr)
+	}
+	return pollableOpener(open)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPSerialBridge(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the bridge may start streaming in the middle of a line
+		_, _ = conn.Write([]byte("0820,1\r\nC1.23,068,120,054,0820,1\r\nC1.23,068,120,055,0820,1\r\n"))
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf)
+	}()
+
+	open := func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		t.Fatal("local serial device opened for a serial bridge")
+		return nil, nil
+	}
+	collector, err := newMaraXCollector(open, tcpScheme+listener.Addr().String())
+	require.NoError(t, err)
+	defer collector.close()
+
+	status, err := collector.collectDataFromSerial(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint16(55), status.hxTemp)
+}
+
+func TestTCPSerialBridgeDialError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = newMaraXCollector(serial.Open, tcpScheme+addr)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to open serial device at "+tcpScheme+addr)
+}
+
+func TestValidateTCPSerialDevice(t *testing.T) {
+	defer func(device string) { *serialDevice = device }(*serialDevice)
+
+	*serialDevice = "tcp://mara-x.local:3333"
+	assert.NoError(t, validateFlags())
+
+	*serialDevice = "tcp://mara-x.local"
+	err := validateFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host:port")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// exporterVersion, exporterCommit and exporterBuildDate describe the build
+// of the exporter. They are set at build time with -ldflags, for example
+// "-X main.exporterVersion=v1.2.0 -X main.exporterCommit=$(git rev-parse HEAD)".
+var (
+	exporterVersion   = "dev"
+	exporterCommit    = "unknown"
+	exporterBuildDate = "unknown"
+)
+
+// versionString returns the version of the exporter as printed by -version.
+func versionString() string {
+	return fmt.Sprintf("mara-xporter %s (commit %s, built %s, %s)",
+		exporterVersion, exporterCommit, exporterBuildDate, runtime.Version())
+}
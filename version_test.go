@@ -0,0 +1,39 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInfo(t *testing.T) {
+	defer func(version, commit, date string) {
+		exporterVersion = version
+		exporterCommit = commit
+		exporterBuildDate = date
+	}(exporterVersion, exporterCommit, exporterBuildDate)
+	exporterVersion = "v1.2.0"
+	exporterCommit = "81c93af"
+	exporterBuildDate = "2021-03-01T12:00:00Z"
+
+	families := gather(t, newCollector(&fakePort{}, serial.OpenOptions{}))
+	family, ok := families["mara_x_build_info"]
+	require.True(t, ok)
+	require.Len(t, family.GetMetric(), 1)
+	labels := make(map[string]string)
+	for _, label := range family.GetMetric()[0].GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	assert.Equal(t, map[string]string{
+		"version":    "v1.2.0",
+		"revision":   "81c93af",
+		"build_date": "2021-03-01T12:00:00Z",
+		"goversion":  runtime.Version(),
+	}, labels)
+	assert.Equal(t, float64(1), family.GetMetric()[0].GetGauge().GetValue())
+
+	assert.Equal(t, "mara-xporter v1.2.0 (commit 81c93af, built 2021-03-01T12:00:00Z, "+runtime.Version()+")", versionString())
+}
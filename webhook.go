@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventReady    = "ready"
+	eventOverTemp = "hx_over_temperature"
+)
+
+// webhookEvent is the JSON payload posted to the webhook.
+type webhookEvent struct {
+	Event          string    `json:"event"`
+	Time           time.Time `json:"time"`
+	Mode           mode      `json:"mode"`
+	SteamTemp      uint16    `json:"steamTemp"`
+	HxTemp         uint16    `json:"hxTemp"`
+	ReadyCountdown uint16    `json:"readyCountdown"`
+}
+
+// webhook posts events to a url whenever one of its conditions switches from
+// false to true. An event is not fired again until its cooldown has passed.
+type webhook struct {
+	url string
+	// hxMaxTemp is the heat exchanger temperature above which the
+	// over-temperature event fires. It is disabled when 0.
+	hxMaxTemp uint16
+	cooldown  time.Duration
+	client    *http.Client
+	now       func() time.Time
+
+	mu sync.Mutex
+	// conditions holds the last state of each condition by event.
+	conditions map[string]bool
+	// fired holds the last time each event was fired.
+	fired map[string]time.Time
+}
+
+func newWebhook(url string, hxMaxTemp uint16, cooldown time.Duration, client *http.Client) *webhook {
+	return &webhook{
+		url:        url,
+		hxMaxTemp:  hxMaxTemp,
+		cooldown:   cooldown,
+		client:     client,
+		now:        time.Now,
+		conditions: make(map[string]bool),
+		fired:      make(map[string]time.Time),
+	}
+}
+
+// Emit evaluates the conditions against the status and fires the events of
+// all conditions that became true. The events are sent in the background.
+func (w *webhook) Emit(status *maraXStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.check(eventReady, status.readyCountdown == 0, status)
+	if w.hxMaxTemp > 0 {
+		w.check(eventOverTemp, status.hxTemp > w.hxMaxTemp, status)
+	}
+}
+
+// check fires the event if the condition changed from false to true. The very
+// first evaluation of a condition only records its state. The caller must
+// hold w.mu.
+func (w *webhook) check(event string, condition bool, status *maraXStatus) {
+	previous, known := w.conditions[event]
+	w.conditions[event] = condition
+	if !known || previous || !condition {
+		return
+	}
+
+	now := w.now()
+	if last, ok := w.fired[event]; ok && now.Sub(last) < w.cooldown {
+		return
+	}
+	w.fired[event] = now
+
+	go w.send(webhookEvent{
+		Event:          event,
+		Time:           now,
+		Mode:           status.mode,
+		SteamTemp:      status.steamTemp,
+		HxTemp:         status.hxTemp,
+		ReadyCountdown: status.readyCountdown,
+	})
+}
+
+func (w *webhook) send(event webhookEvent) {
+	if err := w.post(event); err != nil {
+		slog.Warn("error sending event to webhook", "event", event.Event, "err", err)
+	}
+}
+
+func (w *webhook) post(event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
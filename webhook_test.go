@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookFiresOnTransition(t *testing.T) {
+	events := make(chan webhookEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhookEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer server.Close()
+
+	hook := newWebhook(server.URL, 0, time.Minute, http.DefaultClient)
+	for _, countdown := range []uint16{820, 410, 0, 0, 0} {
+		hook.Emit(&maraXStatus{mode: coffee, hxTemp: 93, readyCountdown: countdown})
+	}
+
+	select {
+	case event := <-events:
+		assert.Equal(t, eventReady, event.Event)
+		assert.Equal(t, uint16(93), event.HxTemp)
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "timed out waiting for webhook event")
+	}
+
+	select {
+	case event := <-events:
+		assert.Failf(t, "unexpected webhook event", "%+v", event)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestWebhookCooldown(t *testing.T) {
+	hook := newWebhook("http://127.0.0.1:0", 100, time.Minute, http.DefaultClient)
+	hook.client.Timeout = time.Millisecond
+	now := time.Unix(0, 0)
+	hook.now = func() time.Time { return now }
+
+	hook.Emit(&maraXStatus{hxTemp: 95, readyCountdown: 1})
+	hook.Emit(&maraXStatus{hxTemp: 101, readyCountdown: 1})
+	assert.Equal(t, now, hook.fired[eventOverTemp])
+
+	// dropping below and exceeding the threshold again within the cooldown
+	// does not fire again
+	now = now.Add(time.Second * 30)
+	hook.Emit(&maraXStatus{hxTemp: 95, readyCountdown: 1})
+	hook.Emit(&maraXStatus{hxTemp: 101, readyCountdown: 1})
+	assert.Equal(t, time.Unix(0, 0), hook.fired[eventOverTemp])
+
+	now = now.Add(time.Minute)
+	hook.Emit(&maraXStatus{hxTemp: 95, readyCountdown: 1})
+	hook.Emit(&maraXStatus{hxTemp: 101, readyCountdown: 1})
+	assert.Equal(t, now, hook.fired[eventOverTemp])
+	assert.NotContains(t, hook.fired, eventReady)
+}